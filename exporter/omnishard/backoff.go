@@ -0,0 +1,211 @@
+// Copyright 2019 Omnition Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package omnishard
+
+import (
+	"context"
+	"math/rand"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// BackoffConfig configures the exponential-backoff-with-jitter retry behavior
+// of the omnishard exporter client, modeled on gRPC's connection-backoff spec
+// (https://github.com/grpc/grpc/blob/master/doc/connection-backoff.md).
+type BackoffConfig struct {
+	// BaseDelay is the amount of time to wait before retrying after the first failure.
+	BaseDelay time.Duration
+
+	// Multiplier is the factor by which the delay increases after each failure.
+	Multiplier float64
+
+	// Jitter is the factor by which the computed delay is randomized, e.g. 0.2
+	// means the actual delay is in [delay*0.8, delay*1.2].
+	Jitter float64
+
+	// MaxDelay is the upper bound on the computed delay, before jitter is applied.
+	MaxDelay time.Duration
+
+	// MaxAttempts bounds the number of times a send is attempted (the first
+	// try plus retries) before Retrier.Do gives up and surfaces the failure.
+	// 0 means retry until ctx's deadline expires instead.
+	MaxAttempts int
+}
+
+// DefaultBackoffConfig is the BackoffConfig used when none is supplied.
+var DefaultBackoffConfig = BackoffConfig{
+	BaseDelay:   time.Second,
+	Multiplier:  1.6,
+	Jitter:      0.2,
+	MaxDelay:    120 * time.Second,
+	MaxAttempts: 5,
+}
+
+// Retrier drives one omnishard exporter client's retry/backoff loop and
+// accumulates its retry metrics. Unlike a package-level counter, a Retrier's
+// metrics are scoped to the client that owns it, so multiple clients (e.g.
+// in tests) don't clobber each other's counts.
+type Retrier struct {
+	cfg BackoffConfig
+
+	retryCount       uint64
+	totalBackoffTime int64 // nanoseconds, accessed via atomic.
+}
+
+// NewRetrier returns a Retrier that retries sends per cfg.
+func NewRetrier(cfg BackoffConfig) *Retrier {
+	return &Retrier{cfg: cfg}
+}
+
+// RetryCount returns the number of retries attempted since the last
+// successful send.
+func (r *Retrier) RetryCount() uint64 {
+	return atomic.LoadUint64(&r.retryCount)
+}
+
+// TotalBackoffTime returns the cumulative backoff delay scheduled between
+// retries since this Retrier was created. A delay is counted in full when
+// its wait begins, even if ctx ends the wait early.
+func (r *Retrier) TotalBackoffTime() time.Duration {
+	return time.Duration(atomic.LoadInt64(&r.totalBackoffTime))
+}
+
+// Do calls send repeatedly until it succeeds (returns a nil error), returns
+// an error that isRetryable rejects, ctx's deadline expires, or
+// cfg.MaxAttempts is reached, waiting between attempts per nextRetryDelay.
+// The retry count is reset to 0 on a successful send. onFail, if non-nil, is
+// called once with the last error whenever Do gives up without a successful
+// send, e.g. to invoke a clientSink.onSendFail.
+func (r *Retrier) Do(ctx context.Context, send func() error, onFail func(err error)) error {
+	var lastErr error
+	for attempt := 0; ; attempt++ {
+		lastErr = send()
+		if lastErr == nil {
+			atomic.StoreUint64(&r.retryCount, 0)
+			return nil
+		}
+
+		if !isRetryable(lastErr) {
+			if onFail != nil {
+				onFail(lastErr)
+			}
+			return lastErr
+		}
+
+		if r.cfg.MaxAttempts > 0 && attempt+1 >= r.cfg.MaxAttempts {
+			if onFail != nil {
+				onFail(lastErr)
+			}
+			return lastErr
+		}
+
+		delay := nextRetryDelay(r.cfg, attempt, lastErr)
+		atomic.AddUint64(&r.retryCount, 1)
+		atomic.AddInt64(&r.totalBackoffTime, int64(delay))
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			lastErr = ctx.Err()
+			if onFail != nil {
+				onFail(lastErr)
+			}
+			return lastErr
+		case <-timer.C:
+		}
+	}
+}
+
+// isRetryable reports whether err is worth retrying at all: only Unavailable
+// (the server or a proxy in front of it is down) and ResourceExhausted (the
+// server is shedding load) are transient in the way this backoff loop
+// assumes. Anything else - InvalidArgument, PermissionDenied, etc. - is
+// permanent, and retrying it would just burn MaxAttempts and wall-clock time
+// on a request that will never succeed.
+func isRetryable(err error) bool {
+	st, ok := status.FromError(err)
+	if !ok {
+		return false
+	}
+	switch st.Code() {
+	case codes.Unavailable, codes.ResourceExhausted:
+		return true
+	default:
+		return false
+	}
+}
+
+// backoff computes the delay to wait before the (retries+1)th retry, per
+// cfg's BaseDelay/Multiplier/MaxDelay, then randomizes it by cfg.Jitter.
+func (cfg BackoffConfig) backoff(retries int) time.Duration {
+	if retries == 0 {
+		return cfg.jitter(cfg.BaseDelay)
+	}
+
+	backoff, maxDelay := float64(cfg.BaseDelay), float64(cfg.MaxDelay)
+	for backoff < maxDelay && retries > 0 {
+		backoff *= cfg.Multiplier
+		retries--
+	}
+	if backoff > maxDelay {
+		backoff = maxDelay
+	}
+	return cfg.jitter(time.Duration(backoff))
+}
+
+// jitter randomizes d by plus-or-minus cfg.Jitter.
+func (cfg BackoffConfig) jitter(d time.Duration) time.Duration {
+	if cfg.Jitter <= 0 {
+		return d
+	}
+	delta := cfg.Jitter * (rand.Float64()*2 - 1)
+	return time.Duration(float64(d) * (1 + delta))
+}
+
+// retryInfoFromError extracts the server-supplied retry delay from err's
+// status details, if any. ok is false when err carries no *errdetails.RetryInfo.
+func retryInfoFromError(err error) (delay time.Duration, ok bool) {
+	st, ok := status.FromError(err)
+	if !ok {
+		return 0, false
+	}
+	for _, detail := range st.Details() {
+		if retryInfo, ok := detail.(*errdetails.RetryInfo); ok {
+			d, err := ptypes.Duration(retryInfo.RetryDelay)
+			if err != nil {
+				return 0, false
+			}
+			return d, true
+		}
+	}
+	return 0, false
+}
+
+// nextRetryDelay returns the delay to wait before the (retries+1)th retry of
+// a send that failed with err: the greater of cfg's computed exponential
+// backoff and any server-supplied RetryInfo delay.
+func nextRetryDelay(cfg BackoffConfig, retries int, err error) time.Duration {
+	computed := cfg.backoff(retries)
+	if serverDelay, ok := retryInfoFromError(err); ok && serverDelay > computed {
+		return serverDelay
+	}
+	return computed
+}
@@ -0,0 +1,210 @@
+// Copyright 2019 Omnition Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package omnishard
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func unavailableErr() error {
+	return status.New(codes.Unavailable, "unavailable").Err()
+}
+
+func withRetryInfo(t *testing.T, code codes.Code, delay time.Duration) error {
+	t.Helper()
+	st, err := status.New(code, "retry later").WithDetails(&errdetails.RetryInfo{
+		RetryDelay: ptypes.DurationProto(delay),
+	})
+	if err != nil {
+		t.Fatalf("WithDetails: %v", err)
+	}
+	return st.Err()
+}
+
+// TestIsRetryableGatesOnStatusCode guards the fix for Do retrying every
+// error: only Unavailable/ResourceExhausted are transient enough to be
+// worth retrying, everything else (including non-status errors) is
+// permanent.
+func TestIsRetryableGatesOnStatusCode(t *testing.T) {
+	cases := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"Unavailable", status.New(codes.Unavailable, "down").Err(), true},
+		{"ResourceExhausted", status.New(codes.ResourceExhausted, "throttled").Err(), true},
+		{"InvalidArgument", status.New(codes.InvalidArgument, "bad request").Err(), false},
+		{"PermissionDenied", status.New(codes.PermissionDenied, "nope").Err(), false},
+		{"non-status error", errors.New("boom"), false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := isRetryable(c.err); got != c.want {
+				t.Errorf("isRetryable(%v) = %v, want %v", c.err, got, c.want)
+			}
+		})
+	}
+}
+
+// TestDoStopsImmediatelyOnPermanentError verifies Do never calls send a
+// second time for a non-retryable error, and calls onFail with it right
+// away instead of waiting for MaxAttempts to burn down.
+func TestDoStopsImmediatelyOnPermanentError(t *testing.T) {
+	r := NewRetrier(BackoffConfig{BaseDelay: time.Millisecond, MaxAttempts: 5})
+
+	var sendCalls int
+	permanentErr := status.New(codes.InvalidArgument, "bad request").Err()
+	send := func() error {
+		sendCalls++
+		return permanentErr
+	}
+
+	var failErr error
+	err := r.Do(context.Background(), send, func(e error) { failErr = e })
+
+	if sendCalls != 1 {
+		t.Errorf("send called %d times, want 1", sendCalls)
+	}
+	if err != permanentErr {
+		t.Errorf("Do() error = %v, want %v", err, permanentErr)
+	}
+	if failErr != permanentErr {
+		t.Errorf("onFail error = %v, want %v", failErr, permanentErr)
+	}
+}
+
+// TestDoRetriesRetryableErrorsUntilMaxAttempts verifies a retryable error is
+// sent exactly MaxAttempts times (MaxAttempts-1 retries), then surfaced via
+// onFail.
+func TestDoRetriesRetryableErrorsUntilMaxAttempts(t *testing.T) {
+	r := NewRetrier(BackoffConfig{BaseDelay: time.Millisecond, Multiplier: 1, MaxAttempts: 3})
+
+	var sendCalls int
+	send := func() error {
+		sendCalls++
+		return unavailableErr()
+	}
+
+	var failErr error
+	err := r.Do(context.Background(), send, func(e error) { failErr = e })
+
+	if sendCalls != 3 {
+		t.Errorf("send called %d times, want MaxAttempts (3)", sendCalls)
+	}
+	if err == nil || failErr == nil {
+		t.Fatalf("Do() error = %v, onFail error = %v, want both non-nil", err, failErr)
+	}
+	if r.RetryCount() != 2 {
+		t.Errorf("RetryCount() = %d, want 2 (MaxAttempts-1)", r.RetryCount())
+	}
+}
+
+// TestDoResetsRetryCountOnSuccess verifies a successful send after some
+// failed attempts resets RetryCount to 0.
+func TestDoResetsRetryCountOnSuccess(t *testing.T) {
+	r := NewRetrier(BackoffConfig{BaseDelay: time.Millisecond, Multiplier: 1, MaxAttempts: 5})
+
+	var sendCalls int
+	send := func() error {
+		sendCalls++
+		if sendCalls < 3 {
+			return unavailableErr()
+		}
+		return nil
+	}
+
+	if err := r.Do(context.Background(), send, nil); err != nil {
+		t.Fatalf("Do() error = %v, want nil", err)
+	}
+	if r.RetryCount() != 0 {
+		t.Errorf("RetryCount() = %d, want 0 after a successful send", r.RetryCount())
+	}
+}
+
+// TestNextRetryDelayPrefersServerRetryInfo verifies nextRetryDelay returns
+// the server-supplied RetryInfo delay when it exceeds the computed backoff,
+// and the computed backoff otherwise.
+func TestNextRetryDelayPrefersServerRetryInfo(t *testing.T) {
+	cfg := BackoffConfig{BaseDelay: time.Second, Multiplier: 1.6, MaxDelay: 120 * time.Second, Jitter: 0}
+
+	computed := cfg.backoff(0)
+	if computed != time.Second {
+		t.Fatalf("cfg.backoff(0) = %v, want %v (jitter disabled)", computed, time.Second)
+	}
+
+	t.Run("server delay larger", func(t *testing.T) {
+		err := withRetryInfo(t, codes.Unavailable, 10*time.Second)
+		got := nextRetryDelay(cfg, 0, err)
+		if got != 10*time.Second {
+			t.Errorf("nextRetryDelay() = %v, want the server-supplied 10s", got)
+		}
+	})
+
+	t.Run("server delay smaller than computed", func(t *testing.T) {
+		err := withRetryInfo(t, codes.Unavailable, time.Millisecond)
+		got := nextRetryDelay(cfg, 0, err)
+		if got != computed {
+			t.Errorf("nextRetryDelay() = %v, want the computed %v", got, computed)
+		}
+	})
+
+	t.Run("no RetryInfo", func(t *testing.T) {
+		got := nextRetryDelay(cfg, 0, unavailableErr())
+		if got != computed {
+			t.Errorf("nextRetryDelay() = %v, want the computed %v", got, computed)
+		}
+	})
+}
+
+// TestBackoffConfigJitterStaysWithinBounds verifies jitter never moves d by
+// more than cfg.Jitter in either direction.
+func TestBackoffConfigJitterStaysWithinBounds(t *testing.T) {
+	cfg := BackoffConfig{Jitter: 0.2}
+	d := 10 * time.Second
+	min := time.Duration(float64(d) * 0.8)
+	max := time.Duration(float64(d) * 1.2)
+
+	for i := 0; i < 100; i++ {
+		got := cfg.jitter(d)
+		if got < min || got > max {
+			t.Fatalf("cfg.jitter(%v) = %v, want within [%v, %v]", d, got, min, max)
+		}
+	}
+}
+
+// TestBackoffConfigJitterZeroIsNoop verifies Jitter <= 0 returns d unchanged.
+func TestBackoffConfigJitterZeroIsNoop(t *testing.T) {
+	cfg := BackoffConfig{Jitter: 0}
+	if got := cfg.jitter(5 * time.Second); got != 5*time.Second {
+		t.Errorf("cfg.jitter() with Jitter=0 = %v, want unchanged 5s", got)
+	}
+}
+
+// TestBackoffConfigBackoffCapsAtMaxDelay verifies repeated doubling is
+// clamped to cfg.MaxDelay rather than growing unbounded.
+func TestBackoffConfigBackoffCapsAtMaxDelay(t *testing.T) {
+	cfg := BackoffConfig{BaseDelay: time.Second, Multiplier: 2, MaxDelay: 5 * time.Second, Jitter: 0}
+	if got := cfg.backoff(10); got != 5*time.Second {
+		t.Errorf("cfg.backoff(10) = %v, want the capped MaxDelay %v", got, 5*time.Second)
+	}
+}
@@ -0,0 +1,85 @@
+// Copyright 2019 Omnition Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package binarylog
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// MethodConfig controls which parts of a call are logged for one gRPC
+// method, and how large a message payload may be before it is truncated.
+type MethodConfig struct {
+	Header          bool
+	Message         bool
+	Trailer         bool
+	MaxMessageBytes uint64 // 0 with Message set means "no limit".
+}
+
+// ParseMethodConfig parses one filter entry of the form
+// "<service>/<method>=<spec>", where <spec> is a ';'-separated list drawn
+// from "header", "trailer" and "message[:maxBytes]", e.g.:
+//
+//	"omnishard.OmniShard/Export=header;message:1024"
+//	"omnishard.OmniShard/GetShardingConfig=header;message;trailer"
+//
+// It returns the method name and its parsed MethodConfig.
+func ParseMethodConfig(entry string) (method string, cfg MethodConfig, err error) {
+	parts := strings.SplitN(entry, "=", 2)
+	if len(parts) != 2 {
+		return "", MethodConfig{}, fmt.Errorf("binarylog: invalid filter %q, want \"<method>=<spec>\"", entry)
+	}
+	method = parts[0]
+
+	for _, component := range strings.Split(parts[1], ";") {
+		component = strings.TrimSpace(component)
+		switch {
+		case component == "header":
+			cfg.Header = true
+		case component == "trailer":
+			cfg.Trailer = true
+		case component == "message":
+			cfg.Message = true
+			cfg.MaxMessageBytes = math.MaxUint64
+		case strings.HasPrefix(component, "message:"):
+			cfg.Message = true
+			n, err := strconv.ParseUint(strings.TrimPrefix(component, "message:"), 10, 64)
+			if err != nil {
+				return "", MethodConfig{}, fmt.Errorf("binarylog: invalid message size in %q: %v", entry, err)
+			}
+			cfg.MaxMessageBytes = n
+		default:
+			return "", MethodConfig{}, fmt.Errorf("binarylog: unknown log component %q in %q", component, entry)
+		}
+	}
+
+	return method, cfg, nil
+}
+
+// ParseConfig parses a list of filter entries, one per method, as accepted
+// by ParseMethodConfig, into a method -> MethodConfig map suitable for NewLogger.
+func ParseConfig(entries []string) (map[string]MethodConfig, error) {
+	methods := make(map[string]MethodConfig, len(entries))
+	for _, entry := range entries {
+		method, cfg, err := ParseMethodConfig(entry)
+		if err != nil {
+			return nil, err
+		}
+		methods[method] = cfg
+	}
+	return methods, nil
+}
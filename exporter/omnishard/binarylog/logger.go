@@ -0,0 +1,450 @@
+// Copyright 2019 Omnition Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package binarylog
+
+import (
+	"context"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+	"google.golang.org/grpc"
+	binlogpb "google.golang.org/grpc/binarylog/grpc_binarylog_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// Logger records header/message/trailer/cancel events for the methods
+// configured in Methods, writing entries to Sink.
+//
+// Logger is safe for concurrent use; every RPC gets its own monotonically
+// increasing call ID, and every entry within a call gets its own
+// monotonically increasing sequence ID, matching the fields gRPC's own
+// binarylog subsystem populates.
+type Logger struct {
+	Sink    Sink
+	Methods map[string]MethodConfig
+
+	nextCallID uint64
+}
+
+// NewLogger returns a Logger that writes to sink, logging only the methods
+// present in methods (as produced by ParseConfig). Methods absent from the
+// map are not logged at all.
+func NewLogger(sink Sink, methods map[string]MethodConfig) *Logger {
+	return &Logger{Sink: sink, Methods: methods}
+}
+
+// UnaryServerInterceptor returns a grpc.UnaryServerInterceptor that logs the
+// configured parts of each call. Calls to methods not present in l.Methods
+// are forwarded to handler unmodified and never touch the Sink, so the
+// existing checkRequiredHeaders logic is unaffected either way.
+func (l *Logger) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		cfg, ok := l.Methods[methodName(info.FullMethod)]
+		if !ok {
+			return handler(ctx, req)
+		}
+
+		callID := atomic.AddUint64(&l.nextCallID, 1)
+		var seqID uint64
+		nextSeq := func() uint64 {
+			seqID++
+			return seqID
+		}
+
+		peerAddr := peerAddress(ctx)
+
+		if cfg.Header {
+			l.write(&binlogpb.GrpcLogEntry{
+				Type:                 binlogpb.GrpcLogEntry_EVENT_TYPE_CLIENT_HEADER,
+				Logger:               binlogpb.GrpcLogEntry_LOGGER_SERVER,
+				CallId:               callID,
+				SequenceIdWithinCall: nextSeq(),
+				Payload:              &binlogpb.GrpcLogEntry_ClientHeader{ClientHeader: headerPayload(ctx, info.FullMethod)},
+				Peer:                 peerAddr,
+			})
+		}
+
+		if cfg.Message {
+			if msg, ok := req.(proto.Message); ok {
+				payload, truncated := messagePayload(msg, cfg.MaxMessageBytes)
+				l.write(&binlogpb.GrpcLogEntry{
+					Type:                 binlogpb.GrpcLogEntry_EVENT_TYPE_CLIENT_MESSAGE,
+					Logger:               binlogpb.GrpcLogEntry_LOGGER_SERVER,
+					CallId:               callID,
+					SequenceIdWithinCall: nextSeq(),
+					Payload:              &binlogpb.GrpcLogEntry_Message{Message: payload},
+					PayloadTruncated:     truncated,
+					Peer:                 peerAddr,
+				})
+			}
+		}
+
+		resp, err := handler(ctx, req)
+
+		if cfg.Header {
+			l.write(&binlogpb.GrpcLogEntry{
+				Type:                 binlogpb.GrpcLogEntry_EVENT_TYPE_SERVER_HEADER,
+				Logger:               binlogpb.GrpcLogEntry_LOGGER_SERVER,
+				CallId:               callID,
+				SequenceIdWithinCall: nextSeq(),
+				Peer:                 peerAddr,
+			})
+		}
+
+		if cfg.Message && err == nil {
+			if msg, ok := resp.(proto.Message); ok {
+				payload, truncated := messagePayload(msg, cfg.MaxMessageBytes)
+				l.write(&binlogpb.GrpcLogEntry{
+					Type:                 binlogpb.GrpcLogEntry_EVENT_TYPE_SERVER_MESSAGE,
+					Logger:               binlogpb.GrpcLogEntry_LOGGER_SERVER,
+					CallId:               callID,
+					SequenceIdWithinCall: nextSeq(),
+					Payload:              &binlogpb.GrpcLogEntry_Message{Message: payload},
+					PayloadTruncated:     truncated,
+					Peer:                 peerAddr,
+				})
+			}
+		}
+
+		if cfg.Trailer {
+			l.write(&binlogpb.GrpcLogEntry{
+				Type:                 binlogpb.GrpcLogEntry_EVENT_TYPE_SERVER_TRAILER,
+				Logger:               binlogpb.GrpcLogEntry_LOGGER_SERVER,
+				CallId:               callID,
+				SequenceIdWithinCall: nextSeq(),
+				Payload:              &binlogpb.GrpcLogEntry_Trailer{Trailer: trailerPayload(err)},
+				Peer:                 peerAddr,
+			})
+		}
+
+		if ctx.Err() != nil {
+			l.write(&binlogpb.GrpcLogEntry{
+				Type:                 binlogpb.GrpcLogEntry_EVENT_TYPE_CANCEL,
+				Logger:               binlogpb.GrpcLogEntry_LOGGER_SERVER,
+				CallId:               callID,
+				SequenceIdWithinCall: nextSeq(),
+				Peer:                 peerAddr,
+			})
+		}
+
+		return resp, err
+	}
+}
+
+// UnaryClientInterceptor returns a grpc.UnaryClientInterceptor that logs the
+// configured parts of each call on the dial path, the client-side mirror of
+// UnaryServerInterceptor. Calls to methods not present in l.Methods are
+// forwarded to invoker unmodified and never touch the Sink.
+func (l *Logger) UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		cfg, ok := l.Methods[methodName(method)]
+		if !ok {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+
+		callID := atomic.AddUint64(&l.nextCallID, 1)
+		var seqID uint64
+		nextSeq := func() uint64 {
+			seqID++
+			return seqID
+		}
+
+		if cfg.Header {
+			l.write(&binlogpb.GrpcLogEntry{
+				Type:                 binlogpb.GrpcLogEntry_EVENT_TYPE_CLIENT_HEADER,
+				Logger:               binlogpb.GrpcLogEntry_LOGGER_CLIENT,
+				CallId:               callID,
+				SequenceIdWithinCall: nextSeq(),
+				Payload:              &binlogpb.GrpcLogEntry_ClientHeader{ClientHeader: outgoingHeaderPayload(ctx, method)},
+			})
+		}
+
+		if cfg.Message {
+			if msg, ok := req.(proto.Message); ok {
+				payload, truncated := messagePayload(msg, cfg.MaxMessageBytes)
+				l.write(&binlogpb.GrpcLogEntry{
+					Type:                 binlogpb.GrpcLogEntry_EVENT_TYPE_CLIENT_MESSAGE,
+					Logger:               binlogpb.GrpcLogEntry_LOGGER_CLIENT,
+					CallId:               callID,
+					SequenceIdWithinCall: nextSeq(),
+					Payload:              &binlogpb.GrpcLogEntry_Message{Message: payload},
+					PayloadTruncated:     truncated,
+				})
+			}
+		}
+
+		var peerInfo peer.Peer
+		opts = append(opts, grpc.Peer(&peerInfo))
+		err := invoker(ctx, method, req, reply, cc, opts...)
+
+		peerAddr := peerAddressFromPeer(&peerInfo)
+
+		if cfg.Header {
+			l.write(&binlogpb.GrpcLogEntry{
+				Type:                 binlogpb.GrpcLogEntry_EVENT_TYPE_SERVER_HEADER,
+				Logger:               binlogpb.GrpcLogEntry_LOGGER_CLIENT,
+				CallId:               callID,
+				SequenceIdWithinCall: nextSeq(),
+				Peer:                 peerAddr,
+			})
+		}
+
+		if cfg.Message && err == nil {
+			if msg, ok := reply.(proto.Message); ok {
+				payload, truncated := messagePayload(msg, cfg.MaxMessageBytes)
+				l.write(&binlogpb.GrpcLogEntry{
+					Type:                 binlogpb.GrpcLogEntry_EVENT_TYPE_SERVER_MESSAGE,
+					Logger:               binlogpb.GrpcLogEntry_LOGGER_CLIENT,
+					CallId:               callID,
+					SequenceIdWithinCall: nextSeq(),
+					Payload:              &binlogpb.GrpcLogEntry_Message{Message: payload},
+					PayloadTruncated:     truncated,
+					Peer:                 peerAddr,
+				})
+			}
+		}
+
+		if cfg.Trailer {
+			l.write(&binlogpb.GrpcLogEntry{
+				Type:                 binlogpb.GrpcLogEntry_EVENT_TYPE_SERVER_TRAILER,
+				Logger:               binlogpb.GrpcLogEntry_LOGGER_CLIENT,
+				CallId:               callID,
+				SequenceIdWithinCall: nextSeq(),
+				Payload:              &binlogpb.GrpcLogEntry_Trailer{Trailer: trailerPayload(err)},
+				Peer:                 peerAddr,
+			})
+		}
+
+		if ctx.Err() != nil {
+			l.write(&binlogpb.GrpcLogEntry{
+				Type:                 binlogpb.GrpcLogEntry_EVENT_TYPE_CANCEL,
+				Logger:               binlogpb.GrpcLogEntry_LOGGER_CLIENT,
+				CallId:               callID,
+				SequenceIdWithinCall: nextSeq(),
+				Peer:                 peerAddr,
+			})
+		}
+
+		return err
+	}
+}
+
+// StreamServerInterceptor returns a grpc.StreamServerInterceptor that logs
+// the configured parts of a streaming call: a client header up front, a
+// client/server message per SendMsg/RecvMsg on the wrapped stream, and a
+// trailer/cancel once handler returns, the streaming counterpart of
+// UnaryServerInterceptor.
+func (l *Logger) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		cfg, ok := l.Methods[methodName(info.FullMethod)]
+		if !ok {
+			return handler(srv, ss)
+		}
+
+		callID := atomic.AddUint64(&l.nextCallID, 1)
+		peerAddr := peerAddress(ss.Context())
+
+		logged := &loggingServerStream{ServerStream: ss, logger: l, cfg: cfg, callID: callID, peerAddr: peerAddr}
+
+		if cfg.Header {
+			l.write(&binlogpb.GrpcLogEntry{
+				Type:                 binlogpb.GrpcLogEntry_EVENT_TYPE_CLIENT_HEADER,
+				Logger:               binlogpb.GrpcLogEntry_LOGGER_SERVER,
+				CallId:               callID,
+				SequenceIdWithinCall: logged.nextSeq(),
+				Payload:              &binlogpb.GrpcLogEntry_ClientHeader{ClientHeader: headerPayload(ss.Context(), info.FullMethod)},
+				Peer:                 peerAddr,
+			})
+		}
+
+		err := handler(srv, logged)
+
+		if cfg.Trailer {
+			l.write(&binlogpb.GrpcLogEntry{
+				Type:                 binlogpb.GrpcLogEntry_EVENT_TYPE_SERVER_TRAILER,
+				Logger:               binlogpb.GrpcLogEntry_LOGGER_SERVER,
+				CallId:               callID,
+				SequenceIdWithinCall: logged.nextSeq(),
+				Payload:              &binlogpb.GrpcLogEntry_Trailer{Trailer: trailerPayload(err)},
+				Peer:                 peerAddr,
+			})
+		}
+		if ss.Context().Err() != nil {
+			l.write(&binlogpb.GrpcLogEntry{
+				Type:                 binlogpb.GrpcLogEntry_EVENT_TYPE_CANCEL,
+				Logger:               binlogpb.GrpcLogEntry_LOGGER_SERVER,
+				CallId:               callID,
+				SequenceIdWithinCall: logged.nextSeq(),
+				Peer:                 peerAddr,
+			})
+		}
+
+		return err
+	}
+}
+
+// loggingServerStream wraps a grpc.ServerStream to log each message sent to
+// or received from the client, under the call ID assigned by
+// StreamServerInterceptor.
+type loggingServerStream struct {
+	grpc.ServerStream
+
+	logger   *Logger
+	cfg      MethodConfig
+	callID   uint64
+	peerAddr *binlogpb.Address
+
+	seqID uint64 // accessed via atomic; SendMsg/RecvMsg may run concurrently.
+}
+
+func (s *loggingServerStream) nextSeq() uint64 {
+	return atomic.AddUint64(&s.seqID, 1)
+}
+
+func (s *loggingServerStream) SendMsg(m interface{}) error {
+	err := s.ServerStream.SendMsg(m)
+	if err == nil && s.cfg.Message {
+		if msg, ok := m.(proto.Message); ok {
+			payload, truncated := messagePayload(msg, s.cfg.MaxMessageBytes)
+			s.logger.write(&binlogpb.GrpcLogEntry{
+				Type:                 binlogpb.GrpcLogEntry_EVENT_TYPE_SERVER_MESSAGE,
+				Logger:               binlogpb.GrpcLogEntry_LOGGER_SERVER,
+				CallId:               s.callID,
+				SequenceIdWithinCall: s.nextSeq(),
+				Payload:              &binlogpb.GrpcLogEntry_Message{Message: payload},
+				PayloadTruncated:     truncated,
+				Peer:                 s.peerAddr,
+			})
+		}
+	}
+	return err
+}
+
+func (s *loggingServerStream) RecvMsg(m interface{}) error {
+	err := s.ServerStream.RecvMsg(m)
+	if err == nil && s.cfg.Message {
+		if msg, ok := m.(proto.Message); ok {
+			payload, truncated := messagePayload(msg, s.cfg.MaxMessageBytes)
+			s.logger.write(&binlogpb.GrpcLogEntry{
+				Type:                 binlogpb.GrpcLogEntry_EVENT_TYPE_CLIENT_MESSAGE,
+				Logger:               binlogpb.GrpcLogEntry_LOGGER_SERVER,
+				CallId:               s.callID,
+				SequenceIdWithinCall: s.nextSeq(),
+				Payload:              &binlogpb.GrpcLogEntry_Message{Message: payload},
+				PayloadTruncated:     truncated,
+				Peer:                 s.peerAddr,
+			})
+		}
+	}
+	return err
+}
+
+func (l *Logger) write(entry *binlogpb.GrpcLogEntry) {
+	entry.Timestamp = ptypes.TimestampNow()
+	// Errors writing to the sink must not fail or slow down the RPC; binary
+	// logging is a best-effort debugging aid.
+	_ = l.Sink.Write(entry)
+}
+
+// methodName strips the leading "/" gRPC puts on info.FullMethod so it
+// matches the "<service>/<method>" form used in filter strings.
+func methodName(fullMethod string) string {
+	return strings.TrimPrefix(fullMethod, "/")
+}
+
+func peerAddress(ctx context.Context) *binlogpb.Address {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return nil
+	}
+	return &binlogpb.Address{Address: p.Addr.String()}
+}
+
+// peerAddressFromPeer is peerAddress's client-side counterpart: the peer
+// isn't known until after invoker runs, so UnaryClientInterceptor collects
+// it via the grpc.Peer CallOption instead of reading it off the context.
+func peerAddressFromPeer(p *peer.Peer) *binlogpb.Address {
+	if p == nil || p.Addr == nil {
+		return nil
+	}
+	return &binlogpb.Address{Address: p.Addr.String()}
+}
+
+func headerPayload(ctx context.Context, fullMethod string) *binlogpb.ClientHeader {
+	md, _ := metadata.FromIncomingContext(ctx)
+	return &binlogpb.ClientHeader{
+		Metadata:   metadataToProto(md),
+		MethodName: fullMethod,
+		Timeout:    ptypes.DurationProto(0),
+	}
+}
+
+// outgoingHeaderPayload is headerPayload's client-side counterpart: outgoing
+// metadata lives under a different context key, and the timeout is derived
+// from ctx's deadline rather than always logged as zero.
+func outgoingHeaderPayload(ctx context.Context, fullMethod string) *binlogpb.ClientHeader {
+	md, _ := metadata.FromOutgoingContext(ctx)
+	var timeout time.Duration
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = time.Until(deadline)
+	}
+	return &binlogpb.ClientHeader{
+		Metadata:   metadataToProto(md),
+		MethodName: fullMethod,
+		Timeout:    ptypes.DurationProto(timeout),
+	}
+}
+
+func metadataToProto(md metadata.MD) *binlogpb.Metadata {
+	entries := make([]*binlogpb.MetadataEntry, 0, len(md))
+	for k, vals := range md {
+		for _, v := range vals {
+			entries = append(entries, &binlogpb.MetadataEntry{Key: k, Value: []byte(v)})
+		}
+	}
+	return &binlogpb.Metadata{Entry: entries}
+}
+
+// messagePayload marshals msg into a *binlogpb.Message, truncating Data to
+// maxBytes if set. Length is always the original, untruncated message size,
+// matching gRPC's own binarylog model; truncated reports whether Data was
+// cut, for the caller to set on GrpcLogEntry.PayloadTruncated (Message
+// itself has no truncation field).
+func messagePayload(msg proto.Message, maxBytes uint64) (payload *binlogpb.Message, truncated bool) {
+	data, err := proto.Marshal(msg)
+	if err != nil {
+		return nil, false
+	}
+
+	length := uint32(len(data))
+	if maxBytes > 0 && uint64(len(data)) > maxBytes {
+		data = data[:maxBytes]
+		truncated = true
+	}
+	return &binlogpb.Message{Length: length, Data: data}, truncated
+}
+
+func trailerPayload(err error) *binlogpb.Trailer {
+	st, _ := status.FromError(err)
+	return &binlogpb.Trailer{
+		StatusCode:    uint32(st.Code()),
+		StatusMessage: st.Message(),
+	}
+}
@@ -0,0 +1,167 @@
+// Copyright 2019 Omnition Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package binarylog
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"google.golang.org/grpc"
+	binlogpb "google.golang.org/grpc/binarylog/grpc_binarylog_v1"
+)
+
+// memSink collects entries in memory for assertions, guarded by a mutex
+// since Logger.write may be called from concurrent RPCs.
+type memSink struct {
+	mutex   sync.Mutex
+	entries []*binlogpb.GrpcLogEntry
+}
+
+func (s *memSink) Write(entry *binlogpb.GrpcLogEntry) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.entries = append(s.entries, entry)
+	return nil
+}
+
+func (s *memSink) Close() error { return nil }
+
+func (s *memSink) types() []binlogpb.GrpcLogEntry_EventType {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var types []binlogpb.GrpcLogEntry_EventType
+	for _, e := range s.entries {
+		types = append(types, e.Type)
+	}
+	return types
+}
+
+// TestMessagePayloadReportsOriginalLength guards the Length-on-truncation
+// fix: Length must always be the original marshaled size, matching gRPC's
+// own binarylog model, with the separate truncated bool (which the caller
+// stores on GrpcLogEntry.PayloadTruncated) reporting whether Data was cut -
+// not Length itself reporting the post-truncation size.
+func TestMessagePayloadReportsOriginalLength(t *testing.T) {
+	msg := &wrappers.StringValue{Value: "0123456789"}
+	data, err := proto.Marshal(msg)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	fullLen := uint32(len(data))
+
+	const maxBytes = 4
+	got, truncated := messagePayload(msg, maxBytes)
+	if got.Length != fullLen {
+		t.Errorf("Length = %d, want original length %d", got.Length, fullLen)
+	}
+	if !truncated {
+		t.Error("truncated = false, want true")
+	}
+	if len(got.Data) != maxBytes {
+		t.Errorf("len(Data) = %d, want %d", len(got.Data), maxBytes)
+	}
+
+	untruncated, truncated := messagePayload(msg, 0)
+	if untruncated.Length != fullLen || truncated {
+		t.Errorf("unbounded messagePayload() = %+v, truncated=%v, want Length %d, truncated=false", untruncated, truncated, fullLen)
+	}
+}
+
+// TestUnaryServerInterceptorLogsConfiguredParts exercises the capture path
+// a mock server wires up via newMockServerWithBinaryLog: a configured
+// method must produce header/message/trailer entries tagged as
+// server-side, in request-then-response order.
+func TestUnaryServerInterceptorLogsConfiguredParts(t *testing.T) {
+	sink := &memSink{}
+	methods := map[string]MethodConfig{
+		"svc.Test/Method": {Header: true, Message: true, Trailer: true},
+	}
+	logger := NewLogger(sink, methods)
+
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return &wrappers.StringValue{Value: "reply"}, nil
+	}
+	_, err := logger.UnaryServerInterceptor()(
+		context.Background(),
+		&wrappers.StringValue{Value: "request"},
+		&grpc.UnaryServerInfo{FullMethod: "/svc.Test/Method"},
+		handler,
+	)
+	if err != nil {
+		t.Fatalf("interceptor returned error: %v", err)
+	}
+
+	want := []binlogpb.GrpcLogEntry_EventType{
+		binlogpb.GrpcLogEntry_EVENT_TYPE_CLIENT_HEADER,
+		binlogpb.GrpcLogEntry_EVENT_TYPE_CLIENT_MESSAGE,
+		binlogpb.GrpcLogEntry_EVENT_TYPE_SERVER_HEADER,
+		binlogpb.GrpcLogEntry_EVENT_TYPE_SERVER_MESSAGE,
+		binlogpb.GrpcLogEntry_EVENT_TYPE_SERVER_TRAILER,
+	}
+	got := sink.types()
+	if len(got) != len(want) {
+		t.Fatalf("got %d entries %v, want %d entries %v", len(got), got, len(want), want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("entry %d type = %v, want %v", i, got[i], want[i])
+		}
+	}
+
+	// The request message entry must carry its payload through the
+	// GrpcLogEntry_Message oneof wrapper, not a bare *binlogpb.Message -
+	// the mistake that made this package fail to compile against the real
+	// binlogpb.
+	reqEntry := sink.entries[1]
+	reqPayload, ok := reqEntry.Payload.(*binlogpb.GrpcLogEntry_Message)
+	if !ok {
+		t.Fatalf("request entry Payload = %T, want *binlogpb.GrpcLogEntry_Message", reqEntry.Payload)
+	}
+	if reqPayload.Message.Length == 0 {
+		t.Errorf("request entry Message.Length = 0, want the marshaled request size")
+	}
+
+	trailerEntry := sink.entries[len(sink.entries)-1]
+	if _, ok := trailerEntry.Payload.(*binlogpb.GrpcLogEntry_Trailer); !ok {
+		t.Fatalf("trailer entry Payload = %T, want *binlogpb.GrpcLogEntry_Trailer", trailerEntry.Payload)
+	}
+}
+
+// TestUnaryServerInterceptorSkipsUnconfiguredMethods verifies methods
+// absent from l.Methods never touch the Sink, so per-method filtering
+// stays as cheap as the doc comment promises.
+func TestUnaryServerInterceptorSkipsUnconfiguredMethods(t *testing.T) {
+	sink := &memSink{}
+	logger := NewLogger(sink, map[string]MethodConfig{})
+
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return &wrappers.StringValue{Value: "reply"}, nil
+	}
+	if _, err := logger.UnaryServerInterceptor()(
+		context.Background(),
+		&wrappers.StringValue{Value: "request"},
+		&grpc.UnaryServerInfo{FullMethod: "/svc.Test/Unconfigured"},
+		handler,
+	); err != nil {
+		t.Fatalf("interceptor returned error: %v", err)
+	}
+
+	if got := sink.types(); len(got) != 0 {
+		t.Fatalf("expected no entries for an unconfigured method, got %v", got)
+	}
+}
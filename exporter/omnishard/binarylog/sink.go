@@ -0,0 +1,135 @@
+// Copyright 2019 Omnition Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package binarylog adds an opt-in structured binary logger for the
+// omnishard Export/GetShardingConfig gRPC traffic, modeled on gRPC's own
+// binarylog subsystem (see google.golang.org/grpc/binarylog).
+package binarylog
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/golang/protobuf/proto"
+	binlogpb "google.golang.org/grpc/binarylog/grpc_binarylog_v1"
+)
+
+// Sink persists GrpcLogEntry records. Implementations must be safe for
+// concurrent use.
+type Sink interface {
+	Write(*binlogpb.GrpcLogEntry) error
+	Close() error
+}
+
+// NoopSink discards every entry. It is the default when binary logging is
+// not configured.
+type NoopSink struct{}
+
+// Write implements Sink.
+func (NoopSink) Write(*binlogpb.GrpcLogEntry) error { return nil }
+
+// Close implements Sink.
+func (NoopSink) Close() error { return nil }
+
+// FileSink writes length-prefixed, serialized GrpcLogEntry records to a file,
+// rotating to a new file before a write that would take the current one past
+// MaxSizeBytes.
+type FileSink struct {
+	// Path is the file the sink writes to. On rotation, the current file is
+	// renamed to "<Path>.<n>" and a fresh file is opened at Path.
+	Path string
+
+	// MaxSizeBytes is the size the current file is rotated before exceeding.
+	// 0 disables rotation.
+	MaxSizeBytes int64
+
+	mutex       sync.Mutex
+	file        *os.File
+	currentSize int64
+	generation  int
+}
+
+// NewFileSink opens (or creates) path for appending.
+func NewFileSink(path string, maxSizeBytes int64) (*FileSink, error) {
+	fs := &FileSink{Path: path, MaxSizeBytes: maxSizeBytes}
+	if err := fs.openLocked(); err != nil {
+		return nil, err
+	}
+	return fs, nil
+}
+
+func (fs *FileSink) openLocked() error {
+	f, err := os.OpenFile(fs.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("binarylog: failed to open %q: %v", fs.Path, err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("binarylog: failed to stat %q: %v", fs.Path, err)
+	}
+	fs.file = f
+	fs.currentSize = info.Size()
+	return nil
+}
+
+// Write serializes entry as a 4-byte big-endian length prefix followed by
+// its wire-format bytes, rotating the file first if needed.
+func (fs *FileSink) Write(entry *binlogpb.GrpcLogEntry) error {
+	data, err := proto.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("binarylog: failed to marshal entry: %v", err)
+	}
+
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	if fs.MaxSizeBytes > 0 && fs.currentSize+int64(len(data))+4 > fs.MaxSizeBytes {
+		if err := fs.rotateLocked(); err != nil {
+			return err
+		}
+	}
+
+	var header [4]byte
+	binary.BigEndian.PutUint32(header[:], uint32(len(data)))
+	if _, err := fs.file.Write(header[:]); err != nil {
+		return fmt.Errorf("binarylog: failed to write entry header: %v", err)
+	}
+	if _, err := fs.file.Write(data); err != nil {
+		return fmt.Errorf("binarylog: failed to write entry: %v", err)
+	}
+	fs.currentSize += int64(len(data)) + 4
+	return nil
+}
+
+func (fs *FileSink) rotateLocked() error {
+	if err := fs.file.Close(); err != nil {
+		return fmt.Errorf("binarylog: failed to close %q for rotation: %v", fs.Path, err)
+	}
+	fs.generation++
+	rotatedPath := fmt.Sprintf("%s.%d", fs.Path, fs.generation)
+	if err := os.Rename(fs.Path, rotatedPath); err != nil {
+		return fmt.Errorf("binarylog: failed to rotate %q to %q: %v", fs.Path, rotatedPath, err)
+	}
+	return fs.openLocked()
+}
+
+// Close closes the underlying file.
+func (fs *FileSink) Close() error {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	return fs.file.Close()
+}
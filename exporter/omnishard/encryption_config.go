@@ -0,0 +1,57 @@
+// Copyright 2019 Omnition Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package omnishard
+
+import (
+	"fmt"
+
+	"github.com/signalfx/apm-opentelemetry-collector/exporter/omnishard/keyprovider"
+)
+
+// EncryptionConfig selects the Encryptor that NewRecordSealerFromConfig
+// builds a RecordSealer around. A nil config disables encryption. Nothing in
+// this package applies the resulting RecordSealer to the EncodedRecords sent
+// to the server; see the note on SealedPayload.
+type EncryptionConfig struct {
+	// Provider selects which Encryptor implementation wraps/unwraps the
+	// per-batch data encryption key: "pkcs11" or "key_provider".
+	Provider string `mapstructure:"provider"`
+
+	PKCS11      *keyprovider.PKCS11Config      `mapstructure:"pkcs11"`
+	KeyProvider *keyprovider.KeyProviderConfig `mapstructure:"key_provider"`
+}
+
+// ToEncryptor builds the Encryptor described by cfg, or returns nil, nil if
+// cfg is nil (encryption disabled).
+func (cfg *EncryptionConfig) ToEncryptor() (Encryptor, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+
+	switch cfg.Provider {
+	case "pkcs11":
+		if cfg.PKCS11 == nil {
+			return nil, fmt.Errorf("encryption: provider is %q but no pkcs11 config was supplied", cfg.Provider)
+		}
+		return keyprovider.NewPKCS11Encryptor(cfg.PKCS11)
+	case "key_provider":
+		if cfg.KeyProvider == nil {
+			return nil, fmt.Errorf("encryption: provider is %q but no key_provider config was supplied", cfg.Provider)
+		}
+		return keyprovider.NewKeyProviderEncryptor(cfg.KeyProvider)
+	default:
+		return nil, fmt.Errorf("encryption: unknown provider %q, must be \"pkcs11\" or \"key_provider\"", cfg.Provider)
+	}
+}
@@ -0,0 +1,171 @@
+// Copyright 2019 Omnition Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package omnishard
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"fmt"
+	"io"
+)
+
+// Encryptor wraps and unwraps the per-batch data encryption key (DEK) used to
+// seal EncodedRecord payloads. Implementations hand the DEK to an external
+// key-management system (an HSM, a cloud KMS, ...) and get back an opaque
+// wrapped form that is safe to store and transmit alongside the ciphertext.
+//
+// Implementations live in the exporter/omnishard/keyprovider subpackage.
+type Encryptor interface {
+	// WrapKey wraps dek, returning the wrapped key bytes (stored in
+	// SealedPayload.WrappedKey) and an opaque keyDescriptor identifying
+	// which key/version/provider produced the wrap, for use by UnwrapKey.
+	WrapKey(ctx context.Context, dek []byte) (wrappedKey []byte, keyDescriptor []byte, err error)
+
+	// UnwrapKey reverses WrapKey, recovering the original DEK.
+	UnwrapKey(ctx context.Context, wrappedKey []byte, keyDescriptor []byte) (dek []byte, err error)
+}
+
+// dekSize is the size, in bytes, of the AES-256 data encryption key generated
+// for each sealed batch.
+const dekSize = 32 // AES-256
+
+// generateDEK returns a fresh, random AES-256 data encryption key.
+func generateDEK() ([]byte, error) {
+	dek := make([]byte, dekSize)
+	if _, err := io.ReadFull(rand.Reader, dek); err != nil {
+		return nil, fmt.Errorf("failed to generate data encryption key: %v", err)
+	}
+	return dek, nil
+}
+
+// sealWithDEK encrypts plaintext under dek using AES-256-GCM with a freshly
+// generated nonce, which is prepended to the returned ciphertext.
+func sealWithDEK(dek, plaintext []byte) ([]byte, error) {
+	gcm, err := newGCM(dek)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %v", err)
+	}
+
+	return gcm.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// openWithDEK reverses sealWithDEK, recovering the original plaintext.
+func openWithDEK(dek, sealed []byte) ([]byte, error) {
+	gcm, err := newGCM(dek)
+	if err != nil {
+		return nil, err
+	}
+
+	nonceSize := gcm.NonceSize()
+	if len(sealed) < nonceSize {
+		return nil, fmt.Errorf("sealed payload too short to contain a nonce")
+	}
+	nonce, ciphertext := sealed[:nonceSize], sealed[nonceSize:]
+
+	return gcm.Open(nil, nonce, ciphertext, nil)
+}
+
+func newGCM(dek []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(dek)
+	if err != nil {
+		return nil, fmt.Errorf("invalid data encryption key: %v", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// SealedPayload is the envelope-encrypted form of a batch's serialized
+// EncodedRecord payload: the AES-256-GCM ciphertext, plus everything a
+// receiver needs to recover the DEK and decrypt it again.
+//
+// NOTE: the upstream EncodedRecord proto (omnishardpb, generated into
+// exporter/omnishard/gen, which is not part of this checkout) does not yet
+// carry a KeyEnvelope field to store this alongside the ciphertext on the
+// wire. Until that field exists, RecordSealer only seals/opens payload
+// bytes; wiring SealedPayload onto EncodedRecord itself is left to whoever
+// adds the proto field.
+type SealedPayload struct {
+	Ciphertext    []byte
+	WrappedKey    []byte
+	KeyDescriptor []byte
+}
+
+// RecordSealer seals and opens EncodedRecord payloads using a configured
+// Encryptor, tying together generateDEK, sealWithDEK/openWithDEK and the
+// Encryptor's key-wrapping into the one call a batch encoder or decoder
+// needs to make.
+type RecordSealer struct {
+	enc Encryptor
+}
+
+// NewRecordSealer returns a RecordSealer that wraps/unwraps DEKs with enc.
+func NewRecordSealer(enc Encryptor) *RecordSealer {
+	return &RecordSealer{enc: enc}
+}
+
+// NewRecordSealerFromConfig builds a RecordSealer from cfg, or returns nil,
+// nil if cfg is nil (encryption disabled).
+func NewRecordSealerFromConfig(cfg *EncryptionConfig) (*RecordSealer, error) {
+	enc, err := cfg.ToEncryptor()
+	if err != nil {
+		return nil, err
+	}
+	if enc == nil {
+		return nil, nil
+	}
+	return NewRecordSealer(enc), nil
+}
+
+// Seal generates a fresh DEK, seals plaintext under it, and wraps the DEK
+// for storage alongside the ciphertext.
+func (rs *RecordSealer) Seal(ctx context.Context, plaintext []byte) (*SealedPayload, error) {
+	dek, err := generateDEK()
+	if err != nil {
+		return nil, err
+	}
+
+	wrappedKey, keyDescriptor, err := rs.enc.WrapKey(ctx, dek)
+	if err != nil {
+		return nil, fmt.Errorf("failed to wrap data encryption key: %v", err)
+	}
+
+	ciphertext, err := sealWithDEK(dek, plaintext)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SealedPayload{
+		Ciphertext:    ciphertext,
+		WrappedKey:    wrappedKey,
+		KeyDescriptor: keyDescriptor,
+	}, nil
+}
+
+// Open reverses Seal, unwrapping sealed.WrappedKey and decrypting
+// sealed.Ciphertext under the recovered DEK.
+func (rs *RecordSealer) Open(ctx context.Context, sealed *SealedPayload) ([]byte, error) {
+	dek, err := rs.enc.UnwrapKey(ctx, sealed.WrappedKey, sealed.KeyDescriptor)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap data encryption key: %v", err)
+	}
+
+	return openWithDEK(dek, sealed.Ciphertext)
+}
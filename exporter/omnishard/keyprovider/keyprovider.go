@@ -0,0 +1,151 @@
+// Copyright 2019 Omnition Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keyprovider
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+)
+
+// KeyProviderConfig configures a KeyProviderEncryptor, which delegates
+// wrap/unwrap operations to a configured binary. It is modeled on the JSON
+// "keyprovider" protocol used by several envelope-encryption integrations:
+// a single request/response object is exchanged over the process's
+// stdin/stdout for each operation.
+type KeyProviderConfig struct {
+	// ExecutablePath is the local binary invoked for each wrap/unwrap call.
+	ExecutablePath string `mapstructure:"executable_path"`
+
+	// Args are additional arguments passed to ExecutablePath.
+	Args []string `mapstructure:"args"`
+}
+
+// keyProviderRequest is the JSON object written to the provider binary's stdin.
+type keyProviderRequest struct {
+	Op            string                 `json:"op"`
+	KeyWrapParams map[string]interface{} `json:"keywrapparams,omitempty"`
+	OptsData      string                 `json:"optsdata,omitempty"`
+}
+
+// keyProviderResponse is the JSON object read back from the provider binary's stdout.
+type keyProviderResponse struct {
+	KeyWrapResults keyWrapResults `json:"keywrapresults"`
+}
+
+type keyWrapResults struct {
+	Annotations map[string]string `json:"annotations"`
+}
+
+// KeyProviderEncryptor wraps/unwraps data encryption keys by shelling out to
+// an external key-provider binary for each operation.
+type KeyProviderEncryptor struct {
+	cfg KeyProviderConfig
+}
+
+// NewKeyProviderEncryptor returns an Encryptor backed by the binary described by cfg.
+func NewKeyProviderEncryptor(cfg *KeyProviderConfig) (*KeyProviderEncryptor, error) {
+	if cfg.ExecutablePath == "" {
+		return nil, fmt.Errorf("key_provider: executable_path must be set")
+	}
+	return &KeyProviderEncryptor{cfg: *cfg}, nil
+}
+
+// WrapKey sends dek to the provider binary as a "keywrap" request and returns
+// the wrapped key it reports, along with its annotations as the key descriptor.
+func (e *KeyProviderEncryptor) WrapKey(ctx context.Context, dek []byte) (wrappedKey []byte, keyDescriptor []byte, err error) {
+	req := keyProviderRequest{
+		Op:       "keywrap",
+		OptsData: base64.StdEncoding.EncodeToString(dek),
+	}
+
+	resp, err := e.call(ctx, req)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	descriptor, err := json.Marshal(resp.KeyWrapResults.Annotations)
+	if err != nil {
+		return nil, nil, fmt.Errorf("key_provider: failed to encode key descriptor: %v", err)
+	}
+
+	wrapped, ok := resp.KeyWrapResults.Annotations["wrapped_key"]
+	if !ok {
+		return nil, nil, fmt.Errorf("key_provider: response missing wrapped_key annotation")
+	}
+	wrappedBytes, err := base64.StdEncoding.DecodeString(wrapped)
+	if err != nil {
+		return nil, nil, fmt.Errorf("key_provider: failed to decode wrapped_key: %v", err)
+	}
+
+	return wrappedBytes, descriptor, nil
+}
+
+// UnwrapKey sends wrappedKey and keyDescriptor to the provider binary as a
+// "keyunwrap" request and returns the recovered DEK.
+func (e *KeyProviderEncryptor) UnwrapKey(ctx context.Context, wrappedKey []byte, keyDescriptor []byte) ([]byte, error) {
+	var annotations map[string]string
+	if err := json.Unmarshal(keyDescriptor, &annotations); err != nil {
+		return nil, fmt.Errorf("key_provider: failed to decode key descriptor: %v", err)
+	}
+
+	req := keyProviderRequest{
+		Op: "keyunwrap",
+		KeyWrapParams: map[string]interface{}{
+			"annotations": annotations,
+		},
+		OptsData: base64.StdEncoding.EncodeToString(wrappedKey),
+	}
+
+	resp, err := e.call(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	dek, ok := resp.KeyWrapResults.Annotations["dek"]
+	if !ok {
+		return nil, fmt.Errorf("key_provider: response missing dek annotation")
+	}
+	return base64.StdEncoding.DecodeString(dek)
+}
+
+// call invokes the configured binary once, writing req as JSON to its stdin
+// and parsing its stdout as a keyProviderResponse.
+func (e *KeyProviderEncryptor) call(ctx context.Context, req keyProviderRequest) (*keyProviderResponse, error) {
+	payload, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("key_provider: failed to encode request: %v", err)
+	}
+
+	cmd := exec.CommandContext(ctx, e.cfg.ExecutablePath, e.cfg.Args...)
+	cmd.Stdin = bytes.NewReader(payload)
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("key_provider: %s failed: %v (stderr: %s)", e.cfg.ExecutablePath, err, stderr.String())
+	}
+
+	var resp keyProviderResponse
+	if err := json.Unmarshal(stdout.Bytes(), &resp); err != nil {
+		return nil, fmt.Errorf("key_provider: failed to decode response: %v", err)
+	}
+	return &resp, nil
+}
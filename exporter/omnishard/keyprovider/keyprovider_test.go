@@ -0,0 +1,94 @@
+// Copyright 2019 Omnition Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keyprovider
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeFakeProviderScript writes a shell script to dir that speaks the
+// keyprovider JSON protocol: it replies to a "keywrap" request with a fixed
+// wrapped_key annotation and to "keyunwrap" with a fixed dek annotation,
+// standing in for a real KMS/PKCS11-backed provider binary.
+func writeFakeProviderScript(t *testing.T, dir string) string {
+	t.Helper()
+	path := filepath.Join(dir, "fake-provider.sh")
+	script := `#!/bin/sh
+input=$(cat)
+case "$input" in
+  *'"op":"keywrap"'*)
+    printf '{"keywrapresults":{"annotations":{"wrapped_key":"d3JhcHBlZC1kZWs=","key_id":"test-key"}}}'
+    ;;
+  *'"op":"keyunwrap"'*)
+    printf '{"keywrapresults":{"annotations":{"dek":"cGxhaW4tZGVr"}}}'
+    ;;
+  *)
+    exit 1
+    ;;
+esac
+`
+	if err := ioutil.WriteFile(path, []byte(script), 0o755); err != nil {
+		t.Fatalf("failed to write fake provider script: %v", err)
+	}
+	return path
+}
+
+// TestKeyProviderEncryptorWrapUnwrap exercises the JSON request/response
+// protocol end to end against a fake provider binary: WrapKey's
+// wrapped_key/annotations round-trip through keyDescriptor into UnwrapKey's
+// request, and UnwrapKey recovers the dek annotation the provider returns.
+func TestKeyProviderEncryptorWrapUnwrap(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keyprovider-test")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	enc, err := NewKeyProviderEncryptor(&KeyProviderConfig{
+		ExecutablePath: writeFakeProviderScript(t, dir),
+	})
+	if err != nil {
+		t.Fatalf("NewKeyProviderEncryptor: %v", err)
+	}
+
+	wrappedKey, keyDescriptor, err := enc.WrapKey(context.Background(), []byte("a-dek"))
+	if err != nil {
+		t.Fatalf("WrapKey: %v", err)
+	}
+	if !bytes.Equal(wrappedKey, []byte("wrapped-dek")) {
+		t.Fatalf("WrapKey wrappedKey = %q, want %q", wrappedKey, "wrapped-dek")
+	}
+
+	dek, err := enc.UnwrapKey(context.Background(), wrappedKey, keyDescriptor)
+	if err != nil {
+		t.Fatalf("UnwrapKey: %v", err)
+	}
+	if !bytes.Equal(dek, []byte("plain-dek")) {
+		t.Fatalf("UnwrapKey dek = %q, want %q", dek, "plain-dek")
+	}
+}
+
+// TestNewKeyProviderEncryptorRequiresExecutablePath guards the config
+// validation done up front, before ever shelling out.
+func TestNewKeyProviderEncryptorRequiresExecutablePath(t *testing.T) {
+	if _, err := NewKeyProviderEncryptor(&KeyProviderConfig{}); err == nil {
+		t.Fatal("NewKeyProviderEncryptor with no ExecutablePath succeeded, want an error")
+	}
+}
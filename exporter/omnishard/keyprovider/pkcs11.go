@@ -0,0 +1,199 @@
+// Copyright 2019 Omnition Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keyprovider
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/miekg/pkcs11"
+)
+
+// PKCS11Config configures a PKCS11Encryptor.
+type PKCS11Config struct {
+	// ModulePath is the path to the PKCS#11 shared library to load.
+	ModulePath string `mapstructure:"module_path"`
+
+	// TokenLabel identifies the token/slot to open.
+	TokenLabel string `mapstructure:"token_label"`
+
+	// PIN authenticates to the token.
+	PIN string `mapstructure:"pin"`
+
+	// KeyLabel identifies the wrapping key on the token.
+	KeyLabel string `mapstructure:"key_label"`
+
+	// Mechanism selects the key-wrap mechanism: "rsa_oaep" or "aes_key_wrap".
+	Mechanism string `mapstructure:"mechanism"`
+}
+
+// pkcs11Mechanisms maps the user-facing mechanism names to PKCS#11 mechanism IDs.
+var pkcs11Mechanisms = map[string]uint{
+	"rsa_oaep":     pkcs11.CKM_RSA_PKCS_OAEP,
+	"aes_key_wrap": pkcs11.CKM_AES_KEY_WRAP,
+}
+
+// PKCS11Encryptor wraps/unwraps data encryption keys using a key held on a
+// PKCS#11 token, via C_WrapKey/C_UnwrapKey.
+type PKCS11Encryptor struct {
+	cfg PKCS11Config
+
+	ctx       *pkcs11.Ctx
+	session   pkcs11.SessionHandle
+	keyHandle pkcs11.ObjectHandle
+	mechanism *pkcs11.Mechanism
+}
+
+// NewPKCS11Encryptor opens the configured token, logs in and locates the
+// wrapping key, returning an Encryptor backed by it.
+func NewPKCS11Encryptor(cfg *PKCS11Config) (*PKCS11Encryptor, error) {
+	mechID, ok := pkcs11Mechanisms[cfg.Mechanism]
+	if !ok {
+		return nil, fmt.Errorf("pkcs11: unknown mechanism %q, must be \"rsa_oaep\" or \"aes_key_wrap\"", cfg.Mechanism)
+	}
+
+	ctx := pkcs11.New(cfg.ModulePath)
+	if ctx == nil {
+		return nil, fmt.Errorf("pkcs11: failed to load module %q", cfg.ModulePath)
+	}
+	if err := ctx.Initialize(); err != nil {
+		return nil, fmt.Errorf("pkcs11: failed to initialize module: %v", err)
+	}
+
+	slot, err := findSlotByTokenLabel(ctx, cfg.TokenLabel)
+	if err != nil {
+		ctx.Destroy()
+		return nil, err
+	}
+
+	session, err := ctx.OpenSession(slot, pkcs11.CKF_SERIAL_SESSION|pkcs11.CKF_RW_SESSION)
+	if err != nil {
+		ctx.Destroy()
+		return nil, fmt.Errorf("pkcs11: failed to open session: %v", err)
+	}
+
+	if err := ctx.Login(session, pkcs11.CKU_USER, cfg.PIN); err != nil {
+		ctx.CloseSession(session)
+		ctx.Destroy()
+		return nil, fmt.Errorf("pkcs11: failed to log in: %v", err)
+	}
+
+	keyHandle, err := findKeyByLabel(ctx, session, cfg.KeyLabel)
+	if err != nil {
+		ctx.Logout(session)
+		ctx.CloseSession(session)
+		ctx.Destroy()
+		return nil, err
+	}
+
+	return &PKCS11Encryptor{
+		cfg:       *cfg,
+		ctx:       ctx,
+		session:   session,
+		keyHandle: keyHandle,
+		mechanism: pkcs11.NewMechanism(mechID, nil),
+	}, nil
+}
+
+// WrapKey wraps dek under the configured token key.
+func (e *PKCS11Encryptor) WrapKey(ctx context.Context, dek []byte) (wrappedKey []byte, keyDescriptor []byte, err error) {
+	dekHandle, err := importSecretKey(e.ctx, e.session, dek)
+	if err != nil {
+		return nil, nil, fmt.Errorf("pkcs11: failed to import data encryption key: %v", err)
+	}
+	defer e.ctx.DestroyObject(e.session, dekHandle)
+
+	wrapped, err := e.ctx.WrapKey(e.session, []*pkcs11.Mechanism{e.mechanism}, e.keyHandle, dekHandle)
+	if err != nil {
+		return nil, nil, fmt.Errorf("pkcs11: C_WrapKey failed: %v", err)
+	}
+
+	return wrapped, []byte(e.cfg.KeyLabel), nil
+}
+
+// UnwrapKey reverses WrapKey.
+func (e *PKCS11Encryptor) UnwrapKey(ctx context.Context, wrappedKey []byte, keyDescriptor []byte) ([]byte, error) {
+	template := secretKeyTemplate(len(wrappedKey))
+	dekHandle, err := e.ctx.UnwrapKey(e.session, []*pkcs11.Mechanism{e.mechanism}, e.keyHandle, wrappedKey, template)
+	if err != nil {
+		return nil, fmt.Errorf("pkcs11: C_UnwrapKey failed: %v", err)
+	}
+	defer e.ctx.DestroyObject(e.session, dekHandle)
+
+	attrs, err := e.ctx.GetAttributeValue(e.session, dekHandle, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_VALUE, nil),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("pkcs11: failed to read unwrapped key: %v", err)
+	}
+	return attrs[0].Value, nil
+}
+
+// Close logs out, closes the session and unloads the PKCS#11 module.
+func (e *PKCS11Encryptor) Close() error {
+	e.ctx.Logout(e.session)
+	e.ctx.CloseSession(e.session)
+	e.ctx.Destroy()
+	return nil
+}
+
+func findSlotByTokenLabel(ctx *pkcs11.Ctx, label string) (uint, error) {
+	slots, err := ctx.GetSlotList(true)
+	if err != nil {
+		return 0, fmt.Errorf("pkcs11: failed to list slots: %v", err)
+	}
+	for _, slot := range slots {
+		info, err := ctx.GetTokenInfo(slot)
+		if err == nil && info.Label == label {
+			return slot, nil
+		}
+	}
+	return 0, fmt.Errorf("pkcs11: no token found with label %q", label)
+}
+
+func findKeyByLabel(ctx *pkcs11.Ctx, session pkcs11.SessionHandle, label string) (pkcs11.ObjectHandle, error) {
+	template := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, label),
+	}
+	if err := ctx.FindObjectsInit(session, template); err != nil {
+		return 0, fmt.Errorf("pkcs11: FindObjectsInit failed: %v", err)
+	}
+	defer ctx.FindObjectsFinal(session)
+
+	handles, _, err := ctx.FindObjects(session, 1)
+	if err != nil {
+		return 0, fmt.Errorf("pkcs11: FindObjects failed: %v", err)
+	}
+	if len(handles) == 0 {
+		return 0, fmt.Errorf("pkcs11: no key found with label %q", label)
+	}
+	return handles[0], nil
+}
+
+func importSecretKey(ctx *pkcs11.Ctx, session pkcs11.SessionHandle, key []byte) (pkcs11.ObjectHandle, error) {
+	template := append(secretKeyTemplate(len(key)), pkcs11.NewAttribute(pkcs11.CKA_VALUE, key))
+	return ctx.CreateObject(session, template)
+}
+
+func secretKeyTemplate(keyLen int) []*pkcs11.Attribute {
+	return []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_SECRET_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_AES),
+		pkcs11.NewAttribute(pkcs11.CKA_VALUE_LEN, keyLen),
+		pkcs11.NewAttribute(pkcs11.CKA_EXTRACTABLE, true),
+		pkcs11.NewAttribute(pkcs11.CKA_ENCRYPT, true),
+		pkcs11.NewAttribute(pkcs11.CKA_DECRYPT, true),
+	}
+}
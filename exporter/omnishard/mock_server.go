@@ -28,9 +28,12 @@ import (
 	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 
+	"github.com/signalfx/apm-opentelemetry-collector/exporter/omnishard/binarylog"
 	omnishardpb "github.com/signalfx/apm-opentelemetry-collector/exporter/omnishard/gen"
 )
 
@@ -128,12 +131,19 @@ func (s *gRPCServer) checkRequiredHeaders(ctx context.Context) error {
 	return nil
 }
 
+// omniShardServiceName is the fully qualified gRPC service name under which
+// the OmniShard RPCs are health-checked, in addition to the overall ("")
+// server status.
+const omniShardServiceName = "omnishard.OmniShard"
+
 type mockServer struct {
 	Sink mockServerSink
 
 	RandomServerError bool
 
-	s                          *grpc.Server
+	s      *grpc.Server
+	health *health.Server
+
 	nextResponseCode           omnishardpb.ExportResponse_ResultCode
 	nextResponseShardingConfig *omnishardpb.ShardingConfig
 
@@ -142,10 +152,29 @@ type mockServer struct {
 }
 
 func newMockServer() *mockServer {
+	return newMockServerWithBinaryLog(nil)
+}
+
+// newMockServerWithBinaryLog is like newMockServer, but additionally installs
+// logger's UnaryServerInterceptor on the gRPC server so tests can exercise
+// binary logging of Export/GetShardingConfig traffic. A nil logger disables
+// binary logging, same as newMockServer.
+func newMockServerWithBinaryLog(logger *binarylog.Logger) *mockServer {
+	healthSrv := health.NewServer()
+	healthSrv.SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+	healthSrv.SetServingStatus(omniShardServiceName, healthpb.HealthCheckResponse_NOT_SERVING)
+
+	var serverOpts []grpc.ServerOption
+	if logger != nil {
+		serverOpts = append(serverOpts, grpc.UnaryInterceptor(logger.UnaryServerInterceptor()))
+	}
+
 	server := &mockServer{
-		s:      grpc.NewServer(),
+		s:      grpc.NewServer(serverOpts...),
+		health: healthSrv,
 		config: &omnishardpb.ShardingConfig{},
 	}
+	healthpb.RegisterHealthServer(server.s, server.health)
 	return server
 }
 
@@ -155,10 +184,16 @@ func (srv *mockServer) GetConfig() *omnishardpb.ShardingConfig {
 	return srv.config
 }
 
+// SetConfig installs the sharding config and flips the health status to
+// SERVING: the OmniShard RPCs are only meaningful to call once a sharding
+// config has been loaded.
 func (srv *mockServer) SetConfig(config *omnishardpb.ShardingConfig) {
 	srv.configMutex.Lock()
-	defer srv.configMutex.Unlock()
 	srv.config = config
+	srv.configMutex.Unlock()
+
+	srv.health.SetServingStatus("", healthpb.HealthCheckResponse_SERVING)
+	srv.health.SetServingStatus(omniShardServiceName, healthpb.HealthCheckResponse_SERVING)
 }
 
 func (srv *mockServer) Listen(
@@ -185,6 +220,8 @@ func (srv *mockServer) Listen(
 }
 
 func (srv *mockServer) Stop() {
+	srv.health.SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+	srv.health.SetServingStatus(omniShardServiceName, healthpb.HealthCheckResponse_NOT_SERVING)
 	srv.s.Stop()
 }
 
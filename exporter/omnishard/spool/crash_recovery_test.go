@@ -0,0 +1,112 @@
+// Copyright 2019 Omnition Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package spool
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func testConfig(dir string) Config {
+	return Config{Directory: dir, MaxSegmentBytes: 1 << 20}
+}
+
+// TestReplayAfterRestartSkipsCommittedEntries verifies the crash-recovery
+// path end to end: entries acked with Advance before a restart (modeled by
+// Close followed by Open on the same directory) must not reappear from
+// Replay, including entry 0 - the case the noneCommitted sentinel exists to
+// get right.
+func TestReplayAfterRestartSkipsCommittedEntries(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spool-crash-recovery")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	cfg := testConfig(dir)
+
+	sp, err := Open(cfg)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	var offsets []uint64
+	for i := 0; i < 3; i++ {
+		off, err := sp.Append([]byte{byte(i)})
+		if err != nil {
+			t.Fatalf("Append: %v", err)
+		}
+		offsets = append(offsets, off)
+	}
+	if offsets[0] != 0 {
+		t.Fatalf("expected first offset to be 0, got %d", offsets[0])
+	}
+
+	// Ack entries 0 and 1, leaving 2 unacked, then crash: close without
+	// appending anything further.
+	if err := sp.Advance(offsets[1]); err != nil {
+		t.Fatalf("Advance: %v", err)
+	}
+	if err := sp.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	restarted, err := Open(cfg)
+	if err != nil {
+		t.Fatalf("Open after restart: %v", err)
+	}
+	defer restarted.Close()
+
+	entries, err := restarted.Replay()
+	if err != nil {
+		t.Fatalf("Replay: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("expected 1 unacked entry after restart, got %d: %+v", len(entries), entries)
+	}
+	if entries[0].Offset != offsets[2] {
+		t.Fatalf("expected unacked entry to be offset %d, got %d", offsets[2], entries[0].Offset)
+	}
+}
+
+// TestReplayBeforeAnyAdvanceReturnsEverything guards the off-by-one fix
+// directly: with nothing ever committed, Replay must return entry 0, not
+// silently drop it because committed's zero value used to be
+// indistinguishable from "offset 0 is committed".
+func TestReplayBeforeAnyAdvanceReturnsEverything(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spool-no-advance")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	sp, err := Open(testConfig(dir))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer sp.Close()
+
+	if _, err := sp.Append([]byte("first")); err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+
+	entries, err := sp.Replay()
+	if err != nil {
+		t.Fatalf("Replay: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Offset != 0 {
+		t.Fatalf("expected entry 0 to survive an unacked Replay, got %+v", entries)
+	}
+}
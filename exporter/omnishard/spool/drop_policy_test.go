@@ -0,0 +1,73 @@
+// Copyright 2019 Omnition Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package spool
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+// TestDropOldestBoundsDiskUsageWithoutAdvance models a prolonged downstream
+// outage: Append is called repeatedly and Advance never is, so nothing is
+// ever committed. drop_policy "oldest" must still keep the spool under
+// MaxTotalBytes by evicting the oldest segment regardless of its committed
+// status - the scenario the policy exists for - rather than erroring out
+// once it runs out of committed segments to drop.
+func TestDropOldestBoundsDiskUsageWithoutAdvance(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spool-drop-oldest")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	const payloadSize = 10
+	const entrySize = entryHeaderSize + payloadSize
+
+	sp, err := Open(Config{
+		Directory:       dir,
+		MaxSegmentBytes: entrySize,     // one entry per segment.
+		MaxTotalBytes:   entrySize * 2, // room for two segments at a time.
+		DropPolicy:      DropOldest,
+	})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer sp.Close()
+
+	var offsets []uint64
+	for i := 0; i < 4; i++ {
+		off, err := sp.Append(make([]byte, payloadSize))
+		if err != nil {
+			t.Fatalf("Append #%d: %v (drop_policy=oldest must never error once more than one segment exists)", i, err)
+		}
+		offsets = append(offsets, off)
+	}
+
+	entries, err := sp.Replay()
+	if err != nil {
+		t.Fatalf("Replay: %v", err)
+	}
+
+	wantOffsets := offsets[len(offsets)-2:]
+	if len(entries) != len(wantOffsets) {
+		t.Fatalf("Replay returned %d entries %+v, want the %d most recent offsets %v", len(entries), entries, len(wantOffsets), wantOffsets)
+	}
+	for i, want := range wantOffsets {
+		if entries[i].Offset != want {
+			t.Errorf("entries[%d].Offset = %d, want %d", i, entries[i].Offset, want)
+		}
+	}
+}
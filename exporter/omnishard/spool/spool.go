@@ -0,0 +1,559 @@
+// Copyright 2019 Omnition Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package spool implements a durable, segmented, file-backed write-ahead
+// queue that sits between the omnishard encoder and the gRPC sender, so that
+// encoded records survive a collector restart or an extended outage of the
+// downstream server.
+package spool
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// noneCommitted is the sentinel value of Spool.committed meaning "no entry
+// has been committed yet". Entry offsets start at 0, so 0 cannot be used to
+// mean "nothing committed" without losing the first entry on crash-recovery.
+const noneCommitted = int64(-1)
+
+// committedFileName is the sidecar file Advance persists the committed
+// offset to, so it survives a restart instead of resetting to
+// noneCommitted and causing Replay to re-deliver already-acked entries.
+const committedFileName = "committed"
+
+// DropPolicy controls what Append does when the spool has grown past
+// Config.MaxTotalBytes.
+type DropPolicy string
+
+const (
+	// DropOldest deletes the oldest segment to make room for new entries,
+	// preferring one that is already fully committed but evicting even
+	// uncommitted data if that's what it takes to stay under
+	// MaxTotalBytes - e.g. during a prolonged downstream outage where
+	// nothing ever gets acked.
+	DropOldest DropPolicy = "oldest"
+	// DropNewest rejects the incoming Append, keeping everything already spooled.
+	DropNewest DropPolicy = "newest"
+	// DropBlock makes Append block until room is freed by Advance.
+	DropBlock DropPolicy = "block"
+)
+
+// Config configures a Spool.
+type Config struct {
+	// Directory is where segment files are stored.
+	Directory string `mapstructure:"directory"`
+
+	// MaxSegmentBytes is the size at which a segment is closed and a new one started.
+	MaxSegmentBytes int64 `mapstructure:"max_segment_bytes"`
+
+	// MaxTotalBytes bounds the total size of all segments. 0 means unbounded.
+	MaxTotalBytes int64 `mapstructure:"max_total_bytes"`
+
+	// SyncInterval is how often buffered writes are fsync'd to disk.
+	// Entries are never visible to Replay until they have been synced.
+	SyncInterval time.Duration `mapstructure:"sync_interval"`
+
+	// DropPolicy selects the behavior of Append once MaxTotalBytes is reached.
+	DropPolicy DropPolicy `mapstructure:"drop_policy"`
+}
+
+const segmentFilePrefix = "segment-"
+
+// entryHeaderSize is the per-entry on-disk framing: a uint64 offset, a
+// uint32 payload length and a uint32 crc32 of the payload.
+const entryHeaderSize = 8 + 4 + 4
+
+// Entry is a single record read back from the spool by Replay.
+type Entry struct {
+	Offset  uint64
+	Payload []byte
+}
+
+// Spool is a durable, segmented, crc-checked write-ahead queue.
+//
+// Entries are appended to the current segment and assigned a monotonically
+// increasing offset. Once the server has confirmed receipt of an entry, the
+// caller advances the committed offset via Advance; segments that are
+// entirely below the committed offset are eligible for deletion.
+type Spool struct {
+	cfg Config
+
+	mutex        sync.Mutex
+	spaceFreed   *sync.Cond // signaled whenever Advance frees segment bytes, for DropBlock.
+	nextOffset   uint64
+	committed    int64 // noneCommitted until the first Advance; durable via committedFileName.
+	segments     []*segment
+	current      *segment
+	bytesSynced  int64 // bytes written since the last fsync, for SyncInterval batching.
+	lastSyncTime time.Time
+}
+
+type segment struct {
+	path      string
+	startOff  uint64 // offset of the first entry in this segment.
+	file      *os.File
+	writer    *bufio.Writer
+	size      int64
+	committed bool // true once every entry in this segment is known to be committed.
+}
+
+// Open opens (or creates) the spool directory, replaying any segments found
+// on disk so Replay can return uncommitted entries.
+func Open(cfg Config) (*Spool, error) {
+	if cfg.Directory == "" {
+		return nil, fmt.Errorf("spool: directory must be set")
+	}
+	if cfg.DropPolicy == "" {
+		cfg.DropPolicy = DropBlock
+	}
+	if err := os.MkdirAll(cfg.Directory, 0o755); err != nil {
+		return nil, fmt.Errorf("spool: failed to create directory %q: %v", cfg.Directory, err)
+	}
+
+	s := &Spool{cfg: cfg, committed: noneCommitted}
+	s.spaceFreed = sync.NewCond(&s.mutex)
+
+	if err := s.loadCommitted(); err != nil {
+		return nil, err
+	}
+
+	paths, err := existingSegmentPaths(cfg.Directory)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range paths {
+		seg, err := openSegmentForAppend(p)
+		if err != nil {
+			return nil, err
+		}
+		s.segments = append(s.segments, seg)
+	}
+
+	if len(s.segments) == 0 {
+		seg, err := createSegment(cfg.Directory, 0)
+		if err != nil {
+			return nil, err
+		}
+		s.segments = append(s.segments, seg)
+	}
+
+	s.current = s.segments[len(s.segments)-1]
+	s.nextOffset, err = s.highestOffsetPlusOne()
+	if err != nil {
+		return nil, err
+	}
+	s.lastSyncTime = time.Now()
+
+	return s, nil
+}
+
+// Append seals payload into the current segment, rotating to a new segment
+// once the current one has reached MaxSegmentBytes, and enforces
+// MaxTotalBytes per DropPolicy.
+// It returns the offset assigned to the entry.
+func (s *Spool) Append(payload []byte) (uint64, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := s.enforceMaxTotalBytesLocked(int64(len(payload)) + entryHeaderSize); err != nil {
+		return 0, err
+	}
+
+	if s.cfg.MaxSegmentBytes > 0 && s.current.size >= s.cfg.MaxSegmentBytes {
+		if err := s.rotateLocked(); err != nil {
+			return 0, err
+		}
+	}
+
+	offset := s.nextOffset
+	n, err := writeEntry(s.current.writer, offset, payload)
+	if err != nil {
+		return 0, fmt.Errorf("spool: failed to append entry: %v", err)
+	}
+	s.current.size += int64(n)
+	s.bytesSynced += int64(n)
+	s.nextOffset++
+
+	if s.shouldSyncLocked() {
+		if err := s.syncLocked(); err != nil {
+			return 0, err
+		}
+	}
+
+	return offset, nil
+}
+
+func (s *Spool) shouldSyncLocked() bool {
+	if s.cfg.SyncInterval <= 0 {
+		return true
+	}
+	return time.Since(s.lastSyncTime) >= s.cfg.SyncInterval
+}
+
+func (s *Spool) syncLocked() error {
+	if err := s.current.writer.Flush(); err != nil {
+		return fmt.Errorf("spool: failed to flush segment: %v", err)
+	}
+	if err := s.current.file.Sync(); err != nil {
+		return fmt.Errorf("spool: failed to fsync segment: %v", err)
+	}
+	s.bytesSynced = 0
+	s.lastSyncTime = time.Now()
+	return nil
+}
+
+// Advance marks every entry up to and including offset as committed,
+// durably persisting the new committed offset before returning. Fully
+// committed segments (other than the current one) are deleted.
+func (s *Spool) Advance(offset uint64) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if int64(offset) > s.committed {
+		s.committed = int64(offset)
+		if err := s.persistCommittedLocked(); err != nil {
+			return err
+		}
+	}
+
+	freed := false
+	for s.oldestSegmentCommittedLocked() {
+		oldest := s.segments[0]
+		if err := oldest.file.Close(); err != nil {
+			return fmt.Errorf("spool: failed to close segment %q: %v", oldest.path, err)
+		}
+		if err := os.Remove(oldest.path); err != nil {
+			return fmt.Errorf("spool: failed to remove committed segment %q: %v", oldest.path, err)
+		}
+		s.segments = s.segments[1:]
+		freed = true
+	}
+	if freed {
+		s.spaceFreed.Broadcast()
+	}
+
+	return nil
+}
+
+// oldestSegmentCommittedLocked reports whether segments[0] is not the
+// current segment and every entry it holds is at or below s.committed, i.e.
+// it is safe to delete.
+func (s *Spool) oldestSegmentCommittedLocked() bool {
+	if len(s.segments) <= 1 {
+		return false
+	}
+	nextStart := s.segments[1].startOff
+	return nextStart > 0 && s.committed >= int64(nextStart)-1
+}
+
+// dropOldestLocked evicts segments[0] to make room under MaxTotalBytes. If
+// it is not already fully committed, s.committed is force-advanced past it
+// (and persisted) before it is deleted, so Replay never tries to re-read
+// entries that are no longer on disk: drop_policy "oldest" always bounds
+// disk usage, even across a prolonged downstream outage where nothing ever
+// gets acked, at the cost of losing that unacked data.
+func (s *Spool) dropOldestLocked() error {
+	if len(s.segments) <= 1 {
+		return fmt.Errorf("spool: at capacity (%d bytes) and only the current segment remains", s.cfg.MaxTotalBytes)
+	}
+
+	oldest := s.segments[0]
+	nextStart := s.segments[1].startOff
+	if forced := int64(nextStart) - 1; nextStart > 0 && forced > s.committed {
+		log.Printf("spool: drop_policy=oldest evicting uncommitted segment %q to stay under max_total_bytes", oldest.path)
+		s.committed = forced
+		if err := s.persistCommittedLocked(); err != nil {
+			return err
+		}
+	}
+
+	if err := oldest.file.Close(); err != nil {
+		return fmt.Errorf("spool: failed to close segment %q: %v", oldest.path, err)
+	}
+	if err := os.Remove(oldest.path); err != nil {
+		return fmt.Errorf("spool: failed to remove segment %q: %v", oldest.path, err)
+	}
+	s.segments = s.segments[1:]
+	return nil
+}
+
+// Replay returns every entry with an offset greater than the last Advance
+// call observed across the lifetime of this Spool (i.e. everything not yet
+// known to be committed), in offset order. It is meant to be called once,
+// right after Open, to resume delivery after a restart.
+func (s *Spool) Replay() ([]Entry, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var entries []Entry
+	for _, seg := range s.segments {
+		segEntries, err := readSegment(seg.path)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range segEntries {
+			if int64(e.Offset) > s.committed {
+				entries = append(entries, e)
+			}
+		}
+	}
+	return entries, nil
+}
+
+// loadCommitted reads the durable committed offset written by a prior
+// Advance, leaving s.committed at noneCommitted if the sidecar file does not
+// exist yet (a brand-new spool).
+func (s *Spool) loadCommitted() error {
+	path := filepath.Join(s.cfg.Directory, committedFileName)
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("spool: failed to read committed offset %q: %v", path, err)
+	}
+
+	committed, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return fmt.Errorf("spool: corrupt committed offset %q: %v", path, err)
+	}
+	s.committed = committed
+	return nil
+}
+
+// persistCommittedLocked fsyncs s.committed to committedFileName via a
+// write-to-temp-then-rename, so a crash mid-write never leaves a partially
+// written offset for loadCommitted to trip over.
+func (s *Spool) persistCommittedLocked() error {
+	path := filepath.Join(s.cfg.Directory, committedFileName)
+	tmpPath := path + ".tmp"
+
+	f, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return fmt.Errorf("spool: failed to create %q: %v", tmpPath, err)
+	}
+	if _, err := fmt.Fprintf(f, "%d", s.committed); err != nil {
+		f.Close()
+		return fmt.Errorf("spool: failed to write committed offset: %v", err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("spool: failed to fsync committed offset: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("spool: failed to close %q: %v", tmpPath, err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("spool: failed to persist committed offset: %v", err)
+	}
+	return nil
+}
+
+// Close flushes and closes all open segment files.
+func (s *Spool) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := s.syncLocked(); err != nil {
+		return err
+	}
+	for _, seg := range s.segments {
+		if err := seg.file.Close(); err != nil {
+			return fmt.Errorf("spool: failed to close segment %q: %v", seg.path, err)
+		}
+	}
+	return nil
+}
+
+func (s *Spool) rotateLocked() error {
+	if err := s.syncLocked(); err != nil {
+		return err
+	}
+	seg, err := createSegment(s.cfg.Directory, s.nextOffset)
+	if err != nil {
+		return err
+	}
+	s.segments = append(s.segments, seg)
+	s.current = seg
+	return nil
+}
+
+func (s *Spool) enforceMaxTotalBytesLocked(incoming int64) error {
+	if s.cfg.MaxTotalBytes <= 0 {
+		return nil
+	}
+
+	for s.totalBytesLocked()+incoming > s.cfg.MaxTotalBytes {
+		switch s.cfg.DropPolicy {
+		case DropNewest:
+			return fmt.Errorf("spool: at capacity (%d bytes), dropping newest entry", s.cfg.MaxTotalBytes)
+		case DropOldest:
+			if err := s.dropOldestLocked(); err != nil {
+				return err
+			}
+		default: // DropBlock
+			// Wait for Advance (on another goroutine) to commit and delete
+			// segments. spaceFreed.Wait atomically releases s.mutex while
+			// blocked and reacquires it before returning, so the loop
+			// condition is always re-checked under the lock.
+			s.spaceFreed.Wait()
+		}
+	}
+	return nil
+}
+
+func (s *Spool) totalBytesLocked() int64 {
+	var total int64
+	for _, seg := range s.segments {
+		total += seg.size
+	}
+	return total
+}
+
+func (s *Spool) highestOffsetPlusOne() (uint64, error) {
+	var highest uint64
+	var found bool
+	for _, seg := range s.segments {
+		entries, err := readSegment(seg.path)
+		if err != nil {
+			return 0, err
+		}
+		for _, e := range entries {
+			if !found || e.Offset > highest {
+				highest = e.Offset
+				found = true
+			}
+		}
+	}
+	if !found {
+		return 0, nil
+	}
+	return highest + 1, nil
+}
+
+func writeEntry(w io.Writer, offset uint64, payload []byte) (int, error) {
+	header := make([]byte, entryHeaderSize)
+	binary.BigEndian.PutUint64(header[0:8], offset)
+	binary.BigEndian.PutUint32(header[8:12], uint32(len(payload)))
+	binary.BigEndian.PutUint32(header[12:16], crc32.ChecksumIEEE(payload))
+
+	if _, err := w.Write(header); err != nil {
+		return 0, err
+	}
+	if _, err := w.Write(payload); err != nil {
+		return 0, err
+	}
+	return len(header) + len(payload), nil
+}
+
+func readSegment(path string) ([]Entry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("spool: failed to open segment %q: %v", path, err)
+	}
+	defer f.Close()
+
+	var entries []Entry
+	r := bufio.NewReader(f)
+	header := make([]byte, entryHeaderSize)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			if err == io.EOF {
+				break
+			}
+			// A short/corrupt trailing entry means a crash happened mid-write;
+			// stop replay at the last complete entry rather than failing outright.
+			break
+		}
+
+		offset := binary.BigEndian.Uint64(header[0:8])
+		length := binary.BigEndian.Uint32(header[8:12])
+		wantCRC := binary.BigEndian.Uint32(header[12:16])
+
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			break
+		}
+		if crc32.ChecksumIEEE(payload) != wantCRC {
+			// Corrupt entry; treat the rest of the segment as unreadable.
+			break
+		}
+
+		entries = append(entries, Entry{Offset: offset, Payload: payload})
+	}
+	return entries, nil
+}
+
+func createSegment(dir string, startOff uint64) (*segment, error) {
+	path := segmentPath(dir, startOff)
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("spool: failed to create segment %q: %v", path, err)
+	}
+	return &segment{path: path, startOff: startOff, file: f, writer: bufio.NewWriter(f)}, nil
+}
+
+func openSegmentForAppend(path string) (*segment, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("spool: failed to stat segment %q: %v", path, err)
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("spool: failed to open segment %q: %v", path, err)
+	}
+	startOff, err := startOffsetFromPath(path)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &segment{path: path, startOff: startOff, file: f, writer: bufio.NewWriter(f), size: info.Size()}, nil
+}
+
+func segmentPath(dir string, startOff uint64) string {
+	return filepath.Join(dir, fmt.Sprintf("%s%020d.log", segmentFilePrefix, startOff))
+}
+
+func startOffsetFromPath(path string) (uint64, error) {
+	name := filepath.Base(path)
+	name = strings.TrimPrefix(name, segmentFilePrefix)
+	name = strings.TrimSuffix(name, ".log")
+	off, err := strconv.ParseUint(name, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("spool: unexpected segment file name %q: %v", path, err)
+	}
+	return off, nil
+}
+
+func existingSegmentPaths(dir string) ([]string, error) {
+	matches, err := filepath.Glob(filepath.Join(dir, segmentFilePrefix+"*.log"))
+	if err != nil {
+		return nil, fmt.Errorf("spool: failed to list segment files in %q: %v", dir, err)
+	}
+	sort.Strings(matches)
+	return matches, nil
+}
@@ -0,0 +1,80 @@
+// Copyright 2019 Omnition Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package omnishard
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	omnishardpb "github.com/signalfx/apm-opentelemetry-collector/exporter/omnishard/gen"
+	"github.com/signalfx/apm-opentelemetry-collector/exporter/omnishard/spool"
+)
+
+// TestSpoolAckingClientSinkAdvancesAcrossRestart exercises the real
+// dispatch path spoolAckingClientSink is built for: a successful
+// ExportResponse must advance the spool so that a restart (Close, then
+// Open on the same directory) only replays what the server never
+// acknowledged. It also guards the onSendResponseWithOffset rename - if a
+// caller ever dispatched through the embedded clientSink instead, this
+// test would see the unacked entry survive the restart.
+func TestSpoolAckingClientSinkAdvancesAcrossRestart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spool-acking-sink")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	cfg := spool.Config{Directory: dir, MaxSegmentBytes: 1 << 20}
+	sp, err := spool.Open(cfg)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+
+	var offsets []uint64
+	for i := 0; i < 2; i++ {
+		off, err := sp.Append([]byte{byte(i)})
+		if err != nil {
+			t.Fatalf("Append: %v", err)
+		}
+		offsets = append(offsets, off)
+	}
+
+	sink := newSpoolAckingClientSink(sp)
+	sink.onSendResponseWithOffset(
+		&omnishardpb.EncodedRecord{},
+		nil,
+		&omnishardpb.ExportResponse{ResultCode: omnishardpb.ExportResponse_SUCCESS},
+		offsets[0],
+	)
+
+	if err := sp.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	restarted, err := spool.Open(cfg)
+	if err != nil {
+		t.Fatalf("Open after restart: %v", err)
+	}
+	defer restarted.Close()
+
+	entries, err := restarted.Replay()
+	if err != nil {
+		t.Fatalf("Replay: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Offset != offsets[1] {
+		t.Fatalf("expected only offset %d to survive the restart, got %+v", offsets[1], entries)
+	}
+}
@@ -25,6 +25,7 @@ import (
 	jaeger "github.com/jaegertracing/jaeger/model"
 
 	omnishardpb "github.com/signalfx/apm-opentelemetry-collector/exporter/omnishard/gen"
+	"github.com/signalfx/apm-opentelemetry-collector/exporter/omnishard/spool"
 )
 
 // encoderSink stores results of encoding for later examination in the tests.
@@ -193,3 +194,39 @@ func (cs *clientSink) getResponses() []*omnishardpb.ExportResponse {
 	defer cs.mutex.Unlock()
 	return cs.responses
 }
+
+// spoolAckingClientSink wraps a clientSink and, on a successful ExportResponse,
+// advances the backing spool's committed offset so that a restart (modeled by
+// closing and re-Open'ing the spool) replays only what the server never
+// acknowledged. Tests use it to verify exactly-once-ish delivery across a
+// simulated process crash.
+type spoolAckingClientSink struct {
+	clientSink
+
+	sp *spool.Spool
+}
+
+func newSpoolAckingClientSink(sp *spool.Spool) *spoolAckingClientSink {
+	return &spoolAckingClientSink{sp: sp}
+}
+
+// onSendResponseWithOffset is deliberately not named onSendResponse: that
+// name is already taken by the embedded clientSink's 3-arg method, and a
+// same-named 4-arg method does not override it in Go - callers that hold a
+// *spoolAckingClientSink as a clientSink would silently invoke the base
+// method and never reach the spool.Advance call below. Callers must name
+// this method explicitly so the spool is always acked.
+func (cs *spoolAckingClientSink) onSendResponseWithOffset(
+	responseToRecords *omnishardpb.EncodedRecord,
+	originalSpans []*jaeger.Span,
+	response *omnishardpb.ExportResponse,
+	offset uint64,
+) {
+	cs.clientSink.onSendResponse(responseToRecords, originalSpans, response)
+
+	if response.ResultCode == omnishardpb.ExportResponse_SUCCESS {
+		if err := cs.sp.Advance(offset); err != nil {
+			log.Printf("spoolAckingClientSink: failed to advance spool: %v", err)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+// Copyright 2019 Omnition Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package omnishard
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// tlsClientCredentials holds the TLS settings used when the omnishard exporter
+// dials the server. The field names and semantics mirror the TLS settings
+// accepted by the OpenCensus receiver so that the same operator mental model
+// applies on both sides of the connection.
+type tlsClientCredentials struct {
+	// CAFile is the file path containing the CA certificate(s) used to verify the
+	// server certificate. When empty, the host's root CA set is used.
+	CAFile string `mapstructure:"ca_file"`
+
+	// CertFile and KeyFile configure a client certificate for mutual TLS. Both
+	// must be set together, or both left empty.
+	CertFile string `mapstructure:"cert_file"`
+	KeyFile  string `mapstructure:"key_file"`
+
+	// ServerNameOverride overrides the server name used to verify the hostname
+	// in the server's certificate, for use in testing.
+	ServerNameOverride string `mapstructure:"server_name_override"`
+
+	// MinVersion is the minimum TLS version accepted from the server, e.g. "1.2" or "1.3".
+	// Defaults to Go's crypto/tls default when empty.
+	MinVersion string `mapstructure:"min_version"`
+
+	// CipherSuites restricts the set of cipher suites the client will offer.
+	// Values must match the names returned by tls.CipherSuites()/tls.InsecureCipherSuites().
+	// Defaults to Go's crypto/tls default list when empty.
+	CipherSuites []string `mapstructure:"cipher_suites"`
+}
+
+// ToDialOption builds a grpc.DialOption carrying the configured TLS credentials.
+// If tlsCreds is nil, grpc.WithInsecure() is returned so the client can still dial
+// a plaintext endpoint.
+//
+// The caller is the exporter's client construction code, which would pass
+// this DialOption to grpc.Dial alongside the other client options (analogous
+// to receiver/opencensusreceiver's buildOptions on the server side) - that
+// file does not exist in this checkout, so ToDialOption has no real caller
+// yet. Once it lands, it should call tlsCreds.ToDialOption() once per client
+// and surface any error before ever attempting to dial.
+func (tlsCreds *tlsClientCredentials) ToDialOption() (grpc.DialOption, error) {
+	if tlsCreds == nil {
+		return grpc.WithInsecure(), nil
+	}
+
+	cfg := &tls.Config{
+		ServerName: tlsCreds.ServerNameOverride,
+	}
+
+	if tlsCreds.CAFile != "" {
+		caBytes, err := ioutil.ReadFile(tlsCreds.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read ca_file: %v", err)
+		}
+		certPool := x509.NewCertPool()
+		if !certPool.AppendCertsFromPEM(caBytes) {
+			return nil, fmt.Errorf("failed to parse any certificates from ca_file %q", tlsCreds.CAFile)
+		}
+		cfg.RootCAs = certPool
+	}
+
+	if tlsCreds.CertFile != "" || tlsCreds.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(tlsCreds.CertFile, tlsCreds.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load TLS cert/key pair: %v", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	if tlsCreds.MinVersion != "" {
+		minVersion, ok := tlsVersionsByName[tlsCreds.MinVersion]
+		if !ok {
+			return nil, fmt.Errorf("invalid min_version %q, must be one of \"1.0\", \"1.1\", \"1.2\", \"1.3\"", tlsCreds.MinVersion)
+		}
+		cfg.MinVersion = minVersion
+	}
+
+	if len(tlsCreds.CipherSuites) > 0 {
+		cipherSuites := make([]uint16, 0, len(tlsCreds.CipherSuites))
+		for _, name := range tlsCreds.CipherSuites {
+			id, ok := cipherSuiteIDByName(name)
+			if !ok {
+				return nil, fmt.Errorf("invalid cipher suite %q", name)
+			}
+			cipherSuites = append(cipherSuites, id)
+		}
+		cfg.CipherSuites = cipherSuites
+	}
+
+	return grpc.WithTransportCredentials(credentials.NewTLS(cfg)), nil
+}
+
+// tlsVersionsByName maps the user-facing version strings to the tls.VersionTLSxx constants.
+var tlsVersionsByName = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// cipherSuiteIDByName returns the uint16 ID for a cipher suite name, looking across
+// both the secure and insecure suites that the Go TLS stack knows about.
+func cipherSuiteIDByName(name string) (uint16, bool) {
+	for _, suite := range tls.CipherSuites() {
+		if suite.Name == name {
+			return suite.ID, true
+		}
+	}
+	for _, suite := range tls.InsecureCipherSuites() {
+		if suite.Name == name {
+			return suite.ID, true
+		}
+	}
+	return 0, false
+}
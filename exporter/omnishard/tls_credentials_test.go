@@ -0,0 +1,57 @@
+// Copyright 2019 Omnition Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package omnishard
+
+import "testing"
+
+// TestToDialOptionNilIsInsecure verifies the documented "nil means plaintext"
+// contract.
+func TestToDialOptionNilIsInsecure(t *testing.T) {
+	var tlsCreds *tlsClientCredentials
+	if _, err := tlsCreds.ToDialOption(); err != nil {
+		t.Fatalf("ToDialOption() on a nil *tlsClientCredentials = %v, want nil error", err)
+	}
+}
+
+// TestToDialOptionRejectsBadMinVersion verifies an invalid min_version is
+// rejected up front, before a dial is ever attempted, rather than silently
+// falling back to Go's default.
+func TestToDialOptionRejectsBadMinVersion(t *testing.T) {
+	tlsCreds := &tlsClientCredentials{MinVersion: "1.4"}
+	if _, err := tlsCreds.ToDialOption(); err == nil {
+		t.Fatal("ToDialOption() with min_version=\"1.4\" succeeded, want an error")
+	}
+}
+
+// TestToDialOptionRejectsBadCipherSuite verifies an unrecognized cipher
+// suite name is rejected up front.
+func TestToDialOptionRejectsBadCipherSuite(t *testing.T) {
+	tlsCreds := &tlsClientCredentials{CipherSuites: []string{"NOT_A_REAL_CIPHER_SUITE"}}
+	if _, err := tlsCreds.ToDialOption(); err == nil {
+		t.Fatal("ToDialOption() with an unknown cipher suite succeeded, want an error")
+	}
+}
+
+// TestToDialOptionAcceptsValidConfig verifies a well-formed min_version and
+// cipher_suite pair is accepted.
+func TestToDialOptionAcceptsValidConfig(t *testing.T) {
+	tlsCreds := &tlsClientCredentials{
+		MinVersion:   "1.2",
+		CipherSuites: []string{"TLS_AES_128_GCM_SHA256"},
+	}
+	if _, err := tlsCreds.ToDialOption(); err != nil {
+		t.Fatalf("ToDialOption() with a valid min_version/cipher_suite = %v, want nil error", err)
+	}
+}
@@ -15,7 +15,10 @@
 package opencensusreceiver
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
+	"io/ioutil"
 	"time"
 
 	"github.com/open-telemetry/opentelemetry-collector/config/configmodels"
@@ -58,6 +61,58 @@ type tlsCredentials struct {
 
 	// KeyFile is the file path containing the TLS key.
 	KeyFile string `mapstructure:"key_file"`
+
+	// MinVersion is the minimum TLS version accepted by the server, e.g. "1.2" or "1.3".
+	// Defaults to Go's crypto/tls default when empty.
+	MinVersion string `mapstructure:"min_version"`
+
+	// CipherSuites restricts the set of cipher suites the server will negotiate.
+	// Values must match the names returned by tls.CipherSuites()/tls.InsecureCipherSuites().
+	// Defaults to Go's crypto/tls default list when empty.
+	CipherSuites []string `mapstructure:"cipher_suites"`
+
+	// ClientCAFile is the file path containing the CA certificate(s) used to verify
+	// client certificates when mutual TLS is enabled.
+	ClientCAFile string `mapstructure:"client_ca_file"`
+
+	// ClientAuth controls whether and how the server requests/verifies a client
+	// certificate. One of "no_client_cert" (default), "request_client_cert",
+	// "require_any_client_cert", "verify_client_cert_if_given" or
+	// "require_and_verify_client_cert".
+	ClientAuth string `mapstructure:"client_auth"`
+}
+
+// tlsVersions maps the user-facing version strings to the tls.VersionTLSxx constants.
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// clientAuthTypes maps the user-facing client-auth strings to tls.ClientAuthType constants.
+var clientAuthTypes = map[string]tls.ClientAuthType{
+	"no_client_cert":                 tls.NoClientCert,
+	"request_client_cert":            tls.RequestClientCert,
+	"require_any_client_cert":        tls.RequireAnyClientCert,
+	"verify_client_cert_if_given":    tls.VerifyClientCertIfGiven,
+	"require_and_verify_client_cert": tls.RequireAndVerifyClientCert,
+}
+
+// cipherSuiteByName returns the uint16 ID for a cipher suite name, looking across both
+// the secure and insecure suites that the Go TLS stack knows about.
+func cipherSuiteByName(name string) (uint16, bool) {
+	for _, suite := range tls.CipherSuites() {
+		if suite.Name == name {
+			return suite.ID, true
+		}
+	}
+	for _, suite := range tls.InsecureCipherSuites() {
+		if suite.Name == name {
+			return suite.ID, true
+		}
+	}
+	return 0, false
 }
 
 type serverParametersAndEnforcementPolicy struct {
@@ -158,10 +213,66 @@ func (tlsCreds *tlsCredentials) ToOpenCensusReceiverServerOption() (opt Option,
 		return WithNoopOption(), false, nil
 	}
 
-	transportCreds, err := credentials.NewServerTLSFromFile(tlsCreds.CertFile, tlsCreds.KeyFile)
+	tlsConfig, err := tlsCreds.buildTLSConfig()
 	if err != nil {
 		return nil, false, err
 	}
-	gRPCCredsOpt := grpc.Creds(transportCreds)
+
+	gRPCCredsOpt := grpc.Creds(credentials.NewTLS(tlsConfig))
 	return WithGRPCServerOptions(gRPCCredsOpt), true, nil
 }
+
+// buildTLSConfig validates and translates tlsCreds into a *tls.Config suitable
+// for use with credentials.NewTLS.
+func (tlsCreds *tlsCredentials) buildTLSConfig() (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(tlsCreds.CertFile, tlsCreds.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load TLS cert/key pair: %v", err)
+	}
+
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+	}
+
+	if tlsCreds.MinVersion != "" {
+		minVersion, ok := tlsVersions[tlsCreds.MinVersion]
+		if !ok {
+			return nil, fmt.Errorf("invalid min_version %q, must be one of \"1.0\", \"1.1\", \"1.2\", \"1.3\"", tlsCreds.MinVersion)
+		}
+		cfg.MinVersion = minVersion
+	}
+
+	if len(tlsCreds.CipherSuites) > 0 {
+		cipherSuites := make([]uint16, 0, len(tlsCreds.CipherSuites))
+		for _, name := range tlsCreds.CipherSuites {
+			id, ok := cipherSuiteByName(name)
+			if !ok {
+				return nil, fmt.Errorf("invalid cipher suite %q", name)
+			}
+			cipherSuites = append(cipherSuites, id)
+		}
+		cfg.CipherSuites = cipherSuites
+	}
+
+	if tlsCreds.ClientAuth != "" {
+		clientAuth, ok := clientAuthTypes[tlsCreds.ClientAuth]
+		if !ok {
+			return nil, fmt.Errorf("invalid client_auth %q", tlsCreds.ClientAuth)
+		}
+		cfg.ClientAuth = clientAuth
+	}
+
+	if tlsCreds.ClientCAFile != "" {
+		caBytes, err := ioutil.ReadFile(tlsCreds.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client_ca_file: %v", err)
+		}
+		certPool := x509.NewCertPool()
+		if !certPool.AppendCertsFromPEM(caBytes) {
+			return nil, fmt.Errorf("failed to parse any certificates from client_ca_file %q", tlsCreds.ClientCAFile)
+		}
+		cfg.ClientCAs = certPool
+	}
+
+	return cfg, nil
+}
@@ -0,0 +1,69 @@
+// Copyright 2019 OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package opencensusreceiver
+
+import (
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// ocTraceServiceName is the fully qualified gRPC service name the trace
+// receiver is health-checked under, in addition to the overall ("") status.
+const ocTraceServiceName = "opencensus.proto.agent.trace.v1.TraceService"
+
+// healthServer wraps grpc/health's reference implementation and wires its
+// serving status to the receiver's lifecycle, so that grpc_health_probe and
+// similar orchestrator health checks work out of the box.
+type healthServer struct {
+	*health.Server
+}
+
+// newHealthServer registers a healthServer on s (so it can be queried with
+// the standard grpc.health.v1.Health service) with both the overall and the
+// trace service status set to NOT_SERVING, as is appropriate before the
+// receiver has started accepting connections. The caller is expected to
+// call Ready once the receiver is actually serving, and NotReady again
+// during shutdown, the same way exporter/omnishard/mock_server.go wires its
+// health.Server into SetConfig/Stop.
+//
+// That caller would live in this package's receiver lifecycle file (where
+// Config.buildOptions' Option/WithGRPCServerOptions/WithTraceReceiverOptions
+// are meant to be consumed to build the *grpc.Server and start/stop the
+// octrace.Receiver serving on it), alongside the octrace package itself -
+// neither exists in this checkout, so newHealthServer has no real
+// construction/shutdown path to hook into yet. Once that file lands, it
+// should call newHealthServer(s) right after the server is constructed,
+// hs.Ready() once it starts serving, and hs.NotReady() as the first step of
+// graceful shutdown.
+func newHealthServer(s *grpc.Server) *healthServer {
+	hs := &healthServer{Server: health.NewServer()}
+	hs.NotReady()
+	healthpb.RegisterHealthServer(s, hs.Server)
+	return hs
+}
+
+// Ready marks the overall and trace-service health status as SERVING.
+func (hs *healthServer) Ready() {
+	hs.SetServingStatus("", healthpb.HealthCheckResponse_SERVING)
+	hs.SetServingStatus(ocTraceServiceName, healthpb.HealthCheckResponse_SERVING)
+}
+
+// NotReady marks the overall and trace-service health status as NOT_SERVING,
+// e.g. while the receiver is starting up or shutting down.
+func (hs *healthServer) NotReady() {
+	hs.SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+	hs.SetServingStatus(ocTraceServiceName, healthpb.HealthCheckResponse_NOT_SERVING)
+}